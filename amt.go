@@ -0,0 +1,227 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Intel AMT WS-Management ports.
+const (
+	amtPort    = "16992"
+	amtTLSPort = "16993"
+)
+
+// amtPowerOn is the CIM_PowerManagementService power state for power on.
+const amtPowerOn = 2
+
+// amtPowerRequest is the WS-Management RequestPowerStateChange request.
+// The message ID and power state are filled in with fmt.
+const amtPowerRequest = `<?xml version="1.0" encoding="utf-8"?>
+<Envelope xmlns="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:p="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_PowerManagementService">
+<Header>
+<a:Action>http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_PowerManagementService/RequestPowerStateChange</a:Action>
+<a:To>/wsman</a:To>
+<w:ResourceURI>http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_PowerManagementService</w:ResourceURI>
+<a:MessageID>uuid:%s</a:MessageID>
+<a:ReplyTo><a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>
+<w:OperationTimeout>PT60S</w:OperationTimeout>
+<w:SelectorSet><w:Selector Name="Name">Intel(r) AMT Power Management Service</w:Selector></w:SelectorSet>
+</Header>
+<Body>
+<p:RequestPowerStateChange_INPUT>
+<p:PowerState>%d</p:PowerState>
+<p:ManagedElement>
+<a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address>
+<a:ReferenceParameters>
+<w:ResourceURI>http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ComputerSystem</w:ResourceURI>
+<w:SelectorSet>
+<w:Selector Name="CreationClassName">CIM_ComputerSystem</w:Selector>
+<w:Selector Name="Name">ManagedSystem</w:Selector>
+</w:SelectorSet>
+</a:ReferenceParameters>
+</p:ManagedElement>
+</p:RequestPowerStateChange_INPUT>
+</Body>
+</Envelope>`
+
+// wakeAMT powers on the server by sending a WS-Management
+// RequestPowerStateChange request to its Intel AMT management engine at
+// the configured amt-host.
+func wakeAMT(c *config) error {
+	if c.AMTHost == "" {
+		return errors.New("amt wake-mode requires amt-host")
+	}
+	password, err := secret(c.AMTPassword, c.AMTPasswordCommand)
+	if err != nil {
+		return fmt.Errorf("could not get amt password: %v", err)
+	}
+	user := c.AMTUser
+	if user == "" {
+		user = "admin"
+	}
+	scheme, port := "http", amtPort
+	if c.AMTTLS {
+		scheme, port = "https", amtTLSPort
+	}
+	client, err := tlsClient(c, "amt", c.AMTFingerprint, false)
+	if err != nil {
+		return err
+	}
+	host := c.AMTHost
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+	url := scheme + "://" + host + "/wsman"
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf(amtPowerRequest, hex.EncodeToString(id), amtPowerOn)
+	post := func(auth string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return client.Do(req)
+	}
+	// AMT requires digest authentication, so the first
+	// request obtains the challenge.
+	resp, err := post("")
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		auth, err := digestAuth(resp.Header.Get("WWW-Authenticate"), http.MethodPost, "/wsman", user, password)
+		if err != nil {
+			return fmt.Errorf("amt: %v", err)
+		}
+		resp, err = post(auth)
+		if err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+	reply, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("amt: %s", resp.Status)
+	}
+	var env struct {
+		ReturnValue *int `xml:"Body>RequestPowerStateChange_OUTPUT>ReturnValue"`
+	}
+	err = xml.Unmarshal(reply, &env)
+	if err != nil {
+		return fmt.Errorf("amt: invalid response: %v", err)
+	}
+	if env.ReturnValue == nil {
+		return errors.New("amt: no return value in response")
+	}
+	if *env.ReturnValue != 0 {
+		return fmt.Errorf("amt: power on request failed with return value %d", *env.ReturnValue)
+	}
+	return nil
+}
+
+// digestAuth returns an Authorization header value responding to the
+// HTTP digest authentication challenge for a request with the given
+// method and URI.
+func digestAuth(challenge, method, uri, user, password string) (string, error) {
+	cnonce := make([]byte, 8)
+	_, err := rand.Read(cnonce)
+	if err != nil {
+		return "", err
+	}
+	return digestResponse(challenge, method, uri, user, password, hex.EncodeToString(cnonce))
+}
+
+// digestResponse returns an Authorization header value responding to the
+// HTTP digest authentication challenge as described in RFC 2617, using
+// the given client nonce if the challenge offers the auth qop.
+func digestResponse(challenge, method, uri, user, password, cnonce string) (string, error) {
+	if !strings.HasPrefix(challenge, "Digest ") {
+		return "", fmt.Errorf("unsupported authentication challenge: %q", challenge)
+	}
+	params := make(map[string]string)
+	for _, p := range splitQuoted(strings.TrimPrefix(challenge, "Digest ")) {
+		i := strings.Index(p, "=")
+		if i < 0 {
+			continue
+		}
+		params[strings.ToLower(strings.TrimSpace(p[:i]))] = strings.Trim(strings.TrimSpace(p[i+1:]), `"`)
+	}
+	if alg := params["algorithm"]; alg != "" && !strings.EqualFold(alg, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm: %q", alg)
+	}
+	h := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := h(user + ":" + params["realm"] + ":" + password)
+	ha2 := h(method + ":" + uri)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `Digest username=%q, realm=%q, nonce=%q, uri=%q`, user, params["realm"], params["nonce"], uri)
+	if qop := params["qop"]; qop == "" {
+		fmt.Fprintf(&b, `, response=%q`, h(ha1+":"+params["nonce"]+":"+ha2))
+	} else {
+		var auth bool
+		for _, q := range strings.Split(qop, ",") {
+			auth = auth || strings.TrimSpace(q) == "auth"
+		}
+		if !auth {
+			return "", fmt.Errorf("unsupported digest qop: %q", qop)
+		}
+		const nc = "00000001"
+		response := h(strings.Join([]string{ha1, params["nonce"], nc, cnonce, "auth", ha2}, ":"))
+		fmt.Fprintf(&b, `, qop=auth, nc=%s, cnonce=%q, response=%q`, nc, cnonce, response)
+	}
+	if opaque, ok := params["opaque"]; ok {
+		fmt.Fprintf(&b, `, opaque=%q`, opaque)
+	}
+	return b.String(), nil
+}
+
+// splitQuoted splits s at commas that are not within double quotes.
+func splitQuoted(s string) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i, r := range s {
+		switch r {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
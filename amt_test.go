@@ -0,0 +1,103 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var digestResponseTests = []struct {
+	name      string
+	challenge string
+	method    string
+	uri       string
+	user      string
+	password  string
+
+	want    string
+	wantErr bool
+}{
+	{
+		// RFC 2617 section 3.5.
+		name: "rfc 2617",
+		challenge: `Digest realm="testrealm@host.com", qop="auth,auth-int", ` +
+			`nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+		method: "GET", uri: "/dir/index.html", user: "Mufasa", password: "Circle Of Life",
+		want: `Digest username="Mufasa", realm="testrealm@host.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", ` +
+			`uri="/dir/index.html", qop=auth, nc=00000001, cnonce="0a4f113b", ` +
+			`response="6629fae49393a05397450978507c4ef1", opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+	},
+	{
+		// RFC 2069 compatible challenge without qop.
+		name:      "no qop",
+		challenge: `Digest realm="testrealm@host.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093"`,
+		method:    "GET", uri: "/dir/index.html", user: "Mufasa", password: "Circle Of Life",
+		want: `Digest username="Mufasa", realm="testrealm@host.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", ` +
+			`uri="/dir/index.html", response="670fd8c2df070c60b045671b8b24ff02"`,
+	},
+	{
+		name:      "quoted commas",
+		challenge: `Digest realm="Digest:KnC, a=b", nonce="abc,def", algorithm=MD5, qop="auth"`,
+		method:    "POST", uri: "/wsman", user: "admin", password: "P@ssw0rd",
+		want: `Digest username="admin", realm="Digest:KnC, a=b", nonce="abc,def", uri="/wsman", ` +
+			`qop=auth, nc=00000001, cnonce="0a4f113b", response="85f4b707f81c69af875d9d03426e1363"`,
+	},
+
+	{name: "basic", challenge: `Basic realm="AMT"`, wantErr: true},
+	{name: "sha-256", challenge: `Digest realm="AMT", nonce="n", algorithm=SHA-256`, wantErr: true},
+	{name: "auth-int only", challenge: `Digest realm="AMT", nonce="n", qop="auth-int"`, wantErr: true},
+}
+
+func TestDigestResponse(t *testing.T) {
+	for _, test := range digestResponseTests {
+		got, err := digestResponse(test.challenge, test.method, test.uri, test.user, test.password, "0a4f113b")
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for %s: got:%v want error:%t", test.name, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("unexpected authorization for %s:\ngot: %s\nwant:%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDigestAuthCnonce(t *testing.T) {
+	const challenge = `Digest realm="AMT", nonce="n", qop="auth"`
+	a, err := digestAuth(challenge, "POST", "/wsman", "admin", "password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := digestAuth(challenge, "POST", "/wsman", "admin", "password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a == b || !strings.Contains(a, "cnonce=") {
+		t.Errorf("expected distinct client nonces: %s and %s", a, b)
+	}
+}
+
+var splitQuotedTests = []struct {
+	in   string
+	want []string
+}{
+	{in: "", want: []string{""}},
+	{in: `a=1`, want: []string{`a=1`}},
+	{in: `a=1, b="2"`, want: []string{`a=1`, ` b="2"`}},
+	{in: `realm="x, y", nonce="z"`, want: []string{`realm="x, y"`, ` nonce="z"`}},
+	{in: `qop="auth,auth-int",stale=false`, want: []string{`qop="auth,auth-int"`, `stale=false`}},
+	{in: `a="1,2,3"`, want: []string{`a="1,2,3"`}},
+	{in: `a=1,`, want: []string{`a=1`, ``}},
+}
+
+func TestSplitQuoted(t *testing.T) {
+	for _, test := range splitQuotedTests {
+		got := splitQuoted(test.in)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected split of %q: got:%q want:%q", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// bindToDevice returns a dialer control function that binds the socket
+// to the named interface with SO_BINDTODEVICE.
+func bindToDevice(name string) func(network, address string, conn syscall.RawConn) error {
+	return func(network, address string, conn syscall.RawConn) error {
+		var err error
+		cerr := conn.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return os.NewSyscallError("setsockopt", err)
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func bindToDevice(name string) func(network, address string, conn syscall.RawConn) error {
+	return func(network, address string, conn syscall.RawConn) error {
+		return fmt.Errorf("%w: not supported on this platform", errNoBindToDevice)
+	}
+}
@@ -3,12 +3,53 @@
 // license that can be found in the LICENSE file.
 
 // bit-user-callback is a Back In Time user callback to determine the current
-// wi-fi connection and wake a target server using Wake-On-Lan.
+// network connection and wake a target server, using Wake-On-Lan or one of a
+// number of other wake methods, when the host is on a trusted network.
 //
-// The executable or a symlink to the executable link should be placed at
-// $XDG_CONFIG_HOME/backintime/user-callback or ~/.config/backintime/user-callback
-// if is $XDG_CONFIG_HOME is not set.
-// Configuration is read from user-callback.json in the same directory.
+// The executable or a symlink to the executable should be placed at
+// $XDG_CONFIG_HOME/backintime/user-callback, or
+// ~/.config/backintime/user-callback if $XDG_CONFIG_HOME is not set.
+// Configuration is read from user-callback.json in the same directory, and
+// may be overridden by BIT_ environment variables and the -set flag. The
+// configuration is checked by running with -check, the valid essid-backend,
+// server-check and wake-mode values are listed by running with
+// -capabilities, and with -daemon bit-user-callback wakes the server
+// whenever the host joins a trusted network.
+//
+// The configuration keys are summarised below. Each is described in detail,
+// with examples, in the README at
+// https://github.com/kortschak/bit-user-callback.
+//
+//   - Profiles: profile, default-reason.
+//   - Network detection: essid-backend, iwconfig-path, nmcli-path,
+//     wpa-ctrl-dir, interfaces, essid, essid-pattern, deny-essids, bssid,
+//     connection-uuid, connectivity-command, wired, wired-interfaces,
+//     wired-gateway-mac, wired-subnet, trusted-subnets, trusted-gateway-macs,
+//     location-host, location-subnets, vpn-interface, vpn-endpoint,
+//     vpn-allowed-ips, tailscale-peer, tailscale-relay, require-wifi,
+//     connectivity-check, connectivity-url, refuse-metered, metered-command,
+//     min-signal, weak-signal-exit.
+//   - Readiness: server, server-check, server-probe-timeout,
+//     server-cert-fingerprint, server-method, server-body,
+//     server-content-type, server-status, server-status-class,
+//     server-response-contains, server-service, server-service-name,
+//     dns-server, min-uptime, uptime-command, uptime-url.
+//   - Waking: wake-mode, wake-mac, wake-local, wake-remote, wake-unicast,
+//     wake-family, wake-interface, wake-all-interfaces, wake-password,
+//     wake-repeat, wake-repeat-interval, wake-fallback, wake-fallback-polls,
+//     wake-confirm, wake-confirm-listen, and the settings for each
+//     wake-mode, prefixed with ssh-relay, tailscale-relay, ipmi, redfish,
+//     amt, ec2, gce, azure, proxmox, libvirt, mqtt, webhook or snmp.
+//   - Waiting: wait, wake-delay, wake-timeout, wake-backoff, wake-max-delay,
+//     expected-boot-time, near-ready-delay, max-consecutive-errors.
+//   - Multiple servers: targets, name, depends-on, concurrency, networks,
+//     network-select.
+//   - Commands: on-ready-command, on-already-ready-command,
+//     after-ready-backup, command-retries, command-retry-delay,
+//     on-failure-email.
+//   - Logging and status: verbose, logfile, logfile-optional, log-context,
+//     pidfile, exit-delay, max-runtime, status-file, recently-ready,
+//     metrics-file, status-socket.
 //
 // See https://github.com/bit-team/user-callback for details of the Back In Time
 // user-callback functionality.
@@ -17,19 +58,23 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net"
-	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/kortschak/wol"
@@ -49,13 +94,166 @@ const (
 )
 
 type config struct {
-	Iwconfig string `json:"iwconfig-path"`
-	LogFile  string `json:"logfile"`
-	Verbose  bool   `json:"verbose"`
+	Iwconfig   string `json:"iwconfig-path"`
+	Nmcli      string `json:"nmcli-path"`
+	WPACtrlDir string `json:"wpa-ctrl-dir"`
+	LogFile    string `json:"logfile"`
+	PIDFile    string `json:"pidfile"`
+	Verbose    bool   `json:"verbose"`
+
+	LogFileOptional bool   `json:"logfile-optional"`
+	StatusSocket    string `json:"status-socket"`
+
+	StatusFile    string   `json:"status-file"`
+	RecentlyReady duration `json:"recently-ready"`
+	MetricsFile   string   `json:"metrics-file"`
+
+	LogContext bool     `json:"log-context"`
+	MaxRuntime duration `json:"max-runtime"`
+	ExitDelay  duration `json:"exit-delay"`
+
+	EssidBackend string `json:"essid-backend"`
+	ServerCheck  checks `json:"server-check"`
+	WakeMode     string `json:"wake-mode"`
+
+	Interfaces stringList `json:"interfaces"`
+
+	DefaultReason string `json:"default-reason"`
+
+	ConnectivityCommand []string `json:"connectivity-command"`
+	ConnectivityCheck   string   `json:"connectivity-check"`
+	ConnectivityURL     string   `json:"connectivity-url"`
+
+	RefuseMetered  bool     `json:"refuse-metered"`
+	MeteredCommand []string `json:"metered-command"`
+
+	MinSignal      int  `json:"min-signal"`
+	WeakSignalExit *int `json:"weak-signal-exit"`
+
+	Wired           bool     `json:"wired"`
+	WiredInterfaces []string `json:"wired-interfaces"`
+	RequireWifi     *bool    `json:"require-wifi"`
+
+	WiredGateway stringList `json:"wired-gateway-mac"`
+	WiredSubnet  stringList `json:"wired-subnet"`
+
+	TrustedSubnets  stringList `json:"trusted-subnets"`
+	TrustedGateways stringList `json:"trusted-gateway-macs"`
+
+	LocationHost    string     `json:"location-host"`
+	LocationSubnets stringList `json:"location-subnets"`
+
+	VPNInterface  string     `json:"vpn-interface"`
+	VPNEndpoint   stringList `json:"vpn-endpoint"`
+	VPNAllowedIPs stringList `json:"vpn-allowed-ips"`
+
+	TailscalePeer         string   `json:"tailscale-peer"`
+	TailscaleRelay        string   `json:"tailscale-relay"`
+	TailscaleRelayCommand []string `json:"tailscale-relay-command"`
+
+	SSHRelay        string   `json:"ssh-relay"`
+	SSHRelayCommand []string `json:"ssh-relay-command"`
+	SSHRelayPacket  bool     `json:"ssh-relay-packet"`
+
+	IPMIHost            string   `json:"ipmi-host"`
+	IPMIInterface       string   `json:"ipmi-interface"`
+	IPMIUser            string   `json:"ipmi-user"`
+	IPMIPassword        string   `json:"ipmi-password"`
+	IPMIPasswordCommand []string `json:"ipmi-password-command"`
+
+	RedfishURL             string   `json:"redfish-url"`
+	RedfishSystem          string   `json:"redfish-system"`
+	RedfishUser            string   `json:"redfish-user"`
+	RedfishPassword        string   `json:"redfish-password"`
+	RedfishPasswordCommand []string `json:"redfish-password-command"`
+	RedfishFingerprint     string   `json:"redfish-cert-fingerprint"`
+	RedfishInsecure        bool     `json:"redfish-insecure"`
+
+	AMTHost            string   `json:"amt-host"`
+	AMTUser            string   `json:"amt-user"`
+	AMTPassword        string   `json:"amt-password"`
+	AMTPasswordCommand []string `json:"amt-password-command"`
+	AMTTLS             bool     `json:"amt-tls"`
+	AMTFingerprint     string   `json:"amt-cert-fingerprint"`
+
+	EC2Instance string `json:"ec2-instance"`
+	EC2Region   string `json:"ec2-region"`
+	EC2Profile  string `json:"ec2-profile"`
+
+	GCEInstance string `json:"gce-instance"`
+	GCEZone     string `json:"gce-zone"`
+	GCEProject  string `json:"gce-project"`
+
+	AzureVM            string `json:"azure-vm"`
+	AzureResourceGroup string `json:"azure-resource-group"`
+	AzureSubscription  string `json:"azure-subscription"`
+
+	ProxmoxURL           string   `json:"proxmox-url"`
+	ProxmoxNode          string   `json:"proxmox-node"`
+	ProxmoxVMID          int      `json:"proxmox-vmid"`
+	ProxmoxType          string   `json:"proxmox-type"`
+	ProxmoxToken         string   `json:"proxmox-token"`
+	ProxmoxSecret        string   `json:"proxmox-secret"`
+	ProxmoxSecretCommand []string `json:"proxmox-secret-command"`
+	ProxmoxFingerprint   string   `json:"proxmox-cert-fingerprint"`
+	ProxmoxInsecure      bool     `json:"proxmox-insecure"`
+
+	LibvirtURI    string `json:"libvirt-uri"`
+	LibvirtDomain string `json:"libvirt-domain"`
 
-	Profile string `json:"profile"`
-	ESSID   string `json:"essid"`
-	Server  string `json:"server"`
+	MQTTBroker          string   `json:"mqtt-broker"`
+	MQTTTopic           string   `json:"mqtt-topic"`
+	MQTTPayload         string   `json:"mqtt-payload"`
+	MQTTUser            string   `json:"mqtt-user"`
+	MQTTPassword        string   `json:"mqtt-password"`
+	MQTTPasswordCommand []string `json:"mqtt-password-command"`
+	MQTTTLS             bool     `json:"mqtt-tls"`
+	MQTTFingerprint     string   `json:"mqtt-cert-fingerprint"`
+
+	WebhookURL          string            `json:"webhook-url"`
+	WebhookMethod       string            `json:"webhook-method"`
+	WebhookHeaders      map[string]string `json:"webhook-headers"`
+	WebhookBody         string            `json:"webhook-body"`
+	WebhookTokenCommand []string          `json:"webhook-token-command"`
+	WebhookFingerprint  string            `json:"webhook-cert-fingerprint"`
+
+	SNMPHost                string   `json:"snmp-host"`
+	SNMPOID                 string   `json:"snmp-oid"`
+	SNMPType                string   `json:"snmp-type"`
+	SNMPValue               string   `json:"snmp-value"`
+	SNMPVersion             string   `json:"snmp-version"`
+	SNMPCommunity           string   `json:"snmp-community"`
+	SNMPCommunityCommand    []string `json:"snmp-community-command"`
+	SNMPUser                string   `json:"snmp-user"`
+	SNMPAuthProtocol        string   `json:"snmp-auth-protocol"`
+	SNMPAuthPassword        string   `json:"snmp-auth-password"`
+	SNMPAuthPasswordCommand []string `json:"snmp-auth-password-command"`
+	SNMPPrivProtocol        string   `json:"snmp-priv-protocol"`
+	SNMPPrivPassword        string   `json:"snmp-priv-password"`
+	SNMPPrivPasswordCommand []string `json:"snmp-priv-password-command"`
+
+	Profile        stringList `json:"profile"`
+	ESSID          stringList `json:"essid"`
+	ESSIDPattern   string     `json:"essid-pattern"`
+	DenyESSIDs     stringList `json:"deny-essids"`
+	BSSID          stringList `json:"bssid"`
+	ConnectionUUID string     `json:"connection-uuid"`
+	Server         string     `json:"server"`
+	Fingerprint    string     `json:"server-cert-fingerprint"`
+	Method         string     `json:"server-method"`
+	Body           string     `json:"server-body"`
+	ContentType    string     `json:"server-content-type"`
+	Status         []int      `json:"server-status"`
+	StatusClass    stringList `json:"server-status-class"`
+	Contains       string     `json:"server-response-contains"`
+	ProbeTimeout   duration   `json:"server-probe-timeout"`
+	DNSServer      string     `json:"dns-server"`
+	MinUptime      duration   `json:"min-uptime"`
+	UptimeCommand  []string   `json:"uptime-command"`
+	UptimeURL      string     `json:"uptime-url"`
+
+	ServerService     string `json:"server-service"`
+	ServerServiceName string `json:"server-service-name"`
 
 	MAC     string   `json:"wake-mac"`
 	Delay   duration `json:"wake-delay"`
@@ -63,6 +261,46 @@ type config struct {
 	Local   string   `json:"wake-local"`
 	Remote  string   `json:"wake-remote"`
 	Wait    duration `json:"wait"`
+
+	WakeFamily    string `json:"wake-family"`
+	WakeInterface string `json:"wake-interface"`
+	WakeUnicast   string `json:"wake-unicast"`
+	WakePassword  string `json:"wake-password"`
+
+	WakeAllInterfaces *bool `json:"wake-all-interfaces"`
+
+	WakeConfirm   string `json:"wake-confirm"`
+	ConfirmListen string `json:"wake-confirm-listen"`
+
+	MaxConsecutiveErrors int `json:"max-consecutive-errors"`
+
+	Targets     []map[string]json.RawMessage `json:"targets"`
+	Concurrency int                          `json:"concurrency"`
+
+	Name      string     `json:"name"`
+	DependsOn stringList `json:"depends-on"`
+
+	Networks      []map[string]json.RawMessage `json:"networks"`
+	NetworkSelect string                       `json:"network-select"`
+
+	Backoff          float64  `json:"wake-backoff"`
+	MaxDelay         duration `json:"wake-max-delay"`
+	ExpectedBootTime duration `json:"expected-boot-time"`
+	NearReadyDelay   duration `json:"near-ready-delay"`
+	WakeRepeat       int      `json:"wake-repeat"`
+	RepeatInterval   duration `json:"wake-repeat-interval"`
+
+	WakeFallback  stringList `json:"wake-fallback"`
+	FallbackPolls int        `json:"wake-fallback-polls"`
+
+	OnReady        []string `json:"on-ready-command"`
+	OnAlreadyReady []string `json:"on-already-ready-command"`
+	Backup         []string `json:"after-ready-backup"`
+
+	CommandRetries    int      `json:"command-retries"`
+	CommandRetryDelay duration `json:"command-retry-delay"`
+
+	FailureEmail *emailConfig `json:"on-failure-email"`
 }
 
 type duration time.Duration
@@ -71,14 +309,20 @@ type duration time.Duration
 //   - If the element is absent the duration is zero.
 //   - If the element is parsable as a time.Duration, the parsed value is kept.
 //   - If the element is parsable as a number, that number of seconds is kept.
+//
+// Numbers may be given either as JSON strings or as bare JSON numbers.
 func (d *duration) UnmarshalJSON(data []byte) error {
-	if len(data) == 0 {
+	if len(data) == 0 || string(data) == "null" {
 		*d = 0
 		return nil
 	}
-	text, err := strconv.Unquote(string(data))
-	if err != nil {
-		return err
+	text := string(data)
+	if data[0] == '"' {
+		var err error
+		text, err = strconv.Unquote(text)
+		if err != nil {
+			return err
+		}
 	}
 	t, err := time.ParseDuration(text)
 	if err == nil {
@@ -90,11 +334,15 @@ func (d *duration) UnmarshalJSON(data []byte) error {
 		*d = duration(time.Duration(i) * time.Second)
 		return nil
 	}
-	// This hack is to get around strconv.ParseInt
-	// not handling e-notation for integers.
+	// Fall back to floating point to handle fractional
+	// seconds and e-notation which strconv.ParseInt
+	// does not accept.
 	f, err := strconv.ParseFloat(text, 64)
-	*d = duration(time.Duration(f) * time.Second)
-	return err
+	if err != nil || math.IsNaN(f) || math.Abs(f) > math.MaxInt64/float64(time.Second) {
+		return fmt.Errorf("invalid duration: %q", text)
+	}
+	*d = duration(f * float64(time.Second))
+	return nil
 }
 
 // MarshalJSON marshals a duration according as Go formatted time.Duration.
@@ -102,82 +350,144 @@ func (d duration) MarshalJSON() ([]byte, error) {
 	return []byte(strconv.Quote(time.Duration(d).String())), nil
 }
 
+// stringList is a list of strings that may be given in JSON as either
+// a single string or an array of strings.
+type stringList []string
+
+// UnmarshalJSON unmarshals a JSON string or array of strings into l. An
+// empty string is unmarshaled as an empty list.
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err == nil {
+		if s == "" {
+			*l = nil
+		} else {
+			*l = stringList{s}
+		}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+// MarshalJSON marshals a stringList as a JSON string if it has fewer than
+// two elements, and as an array of strings otherwise.
+func (l stringList) MarshalJSON() ([]byte, error) {
+	switch len(l) {
+	case 0:
+		return []byte(`""`), nil
+	case 1:
+		return json.Marshal(l[0])
+	default:
+		return json.Marshal([]string(l))
+	}
+}
+
 // installLink creates a symbolic link from the Back In Time config directory
 // to the executable.
 func installLink() {
 	exe, err := os.Readlink("/proc/self/exe")
 	if err != nil {
-		log.Fatalf("could not determine executable path: %v", err)
+		log.Printf("could not determine executable path: %v", err)
+		exit(1)
 	}
 	dir, err := configDir()
 	if err != nil {
-		log.Fatalf("could not determine config directory: %v", err)
+		log.Printf("could not determine config directory: %v", err)
+		exit(1)
 	}
 	err = os.Symlink(exe, filepath.Join(dir, "user-callback"))
 	if err != nil {
-		log.Fatalf("could not create symbolic link: %v", err)
+		log.Printf("could not create symbolic link: %v", err)
+		exit(1)
 	}
 }
 
+// defaultConfig returns the default configuration.
+func defaultConfig() config {
+	c := config{
+		Iwconfig:     iwconfig,
+		EssidBackend: defaultEssidBackend,
+		ServerCheck:  checks{{Type: defaultServerCheck}},
+		WakeMode:     defaultWakeMode,
+		Delay:        duration(delay),
+		Timeout:      duration(timeout),
+		Remote:       remote,
+		RequireWifi:  &requireWifi,
+
+		WakeAllInterfaces: &wakeAllInterfaces,
+	}
+	if p, err := exec.LookPath("iwconfig"); err == nil {
+		c.Iwconfig = p
+	}
+	return c
+}
+
 // generateConfig writes a default configuration file.
 func generateConfig() {
-	dir, err := configDir()
+	path, err := configPath()
 	if err != nil {
-		log.Fatalf("could not determine config directory: %v", err)
+		log.Printf("could not determine config directory: %v", err)
+		exit(1)
 	}
-	path := filepath.Join(dir, "user-callback.json")
 
 	f, err := os.Create(path)
 	if err != nil {
-		log.Fatalf("failed to create config file: %v", err)
+		log.Printf("failed to create config file: %v", err)
+		exit(1)
 	}
 	defer f.Close()
 
-	c := config{
-		Iwconfig: iwconfig,
-		Delay:    duration(delay),
-		Timeout:  duration(timeout),
-		Remote:   remote,
-	}
-	if p, err := exec.LookPath("iwconfig"); err == nil {
-		c.Iwconfig = p
-	}
-	b, err := json.MarshalIndent(c, "", "  ")
+	b, err := json.MarshalIndent(defaultConfig(), "", "  ")
 	if err != nil {
-		log.Fatalf("failed to marshal configuration: %v", err)
+		log.Printf("failed to marshal configuration: %v", err)
+		exit(1)
 	}
 	_, err = f.Write(b)
 	if err != nil {
-		log.Fatalf("failed to write configuration: %v", err)
+		log.Printf("failed to write configuration: %v", err)
+		exit(1)
 	}
 
 	fmt.Printf("wrote configuration file to %q\n", path)
 }
 
-// readConfig returns the configuration for user-callback.
+// readConfig returns the configuration for user-callback. Values read from
+// the configuration file are overridden by values set in the environment.
+// If the configuration file does not exist, the configuration is taken only
+// from the environment, provided at least one value is set there.
 func readConfig() (*config, error) {
-	dir, err := configDir()
+	path, err := configPath()
 	if err != nil {
 		return nil, fmt.Errorf("could not determine config directory: %v", err)
 	}
-	path := filepath.Join(dir, "user-callback.json")
-
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open config file: %v", err)
-	}
-	defer f.Close()
 
 	var c config
 	b, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		err = json.Unmarshal(b, &c)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing config file: %v", err)
+		}
+	case os.IsNotExist(err) && envConfigured():
+	default:
+		return nil, fmt.Errorf("failed to open config file: %v", err)
+	}
+	err = applyEnv(&c)
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(b, &c)
+	return &c, nil
+}
+
+// configPath returns the location of the user-callback configuration file.
+func configPath() (string, error) {
+	dir, err := configDir()
 	if err != nil {
-		return nil, fmt.Errorf("error parsing config file: %v", err)
+		return "", err
 	}
-	return &c, nil
+	return filepath.Join(dir, "user-callback.json"), nil
 }
 
 // configDir returns the location of the backintime config directory.
@@ -193,18 +503,35 @@ func configDir() (string, error) {
 	return filepath.Join(u.HomeDir, ".config", "backintime"), nil
 }
 
-// essids returns the ESSIDS of wireless interfaces that the host is connected to.
-func essids() ([]string, error) {
-	const essid = "ESSID:"
+// iwconfigESSIDs returns the ESSIDS of wireless interfaces that the host is
+// connected to using the output of iwconfig.
+func iwconfigESSIDs(c *config) ([]string, error) {
+	conns, err := iwconfigConnections(c)
+	return connectionESSIDs(conns), err
+}
+
+// iwconfigConnections returns the wireless interfaces that the host is
+// connected to and their ESSIDs, access points and signal levels using the
+// output of iwconfig.
+func iwconfigConnections(c *config) ([]connection, error) {
+	const (
+		essid       = "ESSID:"
+		accessPoint = "Access Point:"
+		signalLevel = "Signal level="
+	)
 
-	cmd := exec.Command(iwconfig)
+	path := c.Iwconfig
+	if path == "" {
+		path = iwconfig
+	}
+	cmd := toolCommand(path)
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	err := cmd.Run()
 	if err != nil {
 		return nil, err
 	}
-	var essids []string
+	var conns []connection
 	sc := bufio.NewScanner(&buf)
 	for sc.Scan() {
 		b := bytes.TrimSpace(sc.Bytes())
@@ -215,12 +542,28 @@ func essids() ([]string, error) {
 			s := string(b[i+len(essid):])
 			id, err := strconv.Unquote(s)
 			if err != nil {
-				return essids, fmt.Errorf("%v: %q", err, s)
+				return conns, fmt.Errorf("%v: %q", err, s)
+			}
+			conns = append(conns, connection{essid: id, iface: string(bytes.Fields(b)[0])})
+		}
+		// The access point is reported on a line following
+		// the ESSID line for the same interface.
+		if i := bytes.Index(b, []byte(accessPoint)); i != -1 && len(conns) != 0 {
+			f := bytes.Fields(b[i+len(accessPoint):])
+			if len(f) != 0 {
+				if _, err := net.ParseMAC(string(f[0])); err == nil {
+					conns[len(conns)-1].bssid = string(f[0])
+				}
+			}
+		}
+		if i := bytes.Index(b, []byte(signalLevel)); i != -1 && len(conns) != 0 {
+			f := bytes.Fields(b[i+len(signalLevel):])
+			if len(f) > 1 && string(f[1]) == "dBm" {
+				conns[len(conns)-1].signal, _ = strconv.Atoi(string(f[0]))
 			}
-			essids = append(essids, id)
 		}
 	}
-	return essids, nil
+	return conns, nil
 }
 
 // contains returns whether s matches an element of slice.
@@ -234,54 +577,233 @@ func contains(s string, slice []string) bool {
 }
 
 // wake sends a WOL package to the remote address via the local interface, targeting
-// the given mac address.
-func wake(mac, local, remote string) error {
-	raddr, err := net.ResolveUDPAddr("udp", remote)
+// the given mac address. The network must be "udp4" or "udp6".
+func wake(c *config, network, mac, local, remote string) error {
+	err := checkFamily(network, "remote", remote)
+	if err != nil {
+		return err
+	}
+	raddr, err := resolveUDPAddr(c, network, remote)
 	if err != nil {
 		return fmt.Errorf("could not parse remote %q as a valid UDP address: %v", remote, err)
 	}
 	var laddr *net.UDPAddr
 	if local != "" {
-		laddr, err = net.ResolveUDPAddr("udp", local)
+		err = checkFamily(network, "local", local)
+		if err != nil {
+			return err
+		}
+		laddr, err = resolveUDPAddr(c, network, local)
 		if err != nil {
 			return fmt.Errorf("could not parse local %q as a valid UDP address: %v", local, err)
 		}
+		err = sameFamily(laddr, raddr)
+		if err != nil {
+			return err
+		}
 	}
 
 	hwaddr, err := net.ParseMAC(mac)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not parse %q as a valid MAC address: %v\n", mac, err)
+		return fmt.Errorf("could not parse %q as a valid MAC address: %v", mac, err)
+	}
+	pass, err := c.wakePassword()
+	if err != nil {
+		return err
+	}
+	if c.WakeInterface != "" {
+		err = wakeBound(c.WakeInterface, network, laddr, raddr, magicPacket(hwaddr, pass))
+		if err == nil || laddr == nil || !errors.Is(err, syscall.EPERM) && !errors.Is(err, errNoBindToDevice) {
+			return err
+		}
+		// Without permission to bind to the interface,
+		// rely on the source address to select it.
 	}
-	err = wol.Wake(hwaddr, nil, laddr, raddr)
+	err = wol.Wake(hwaddr, pass, laddr, raddr)
 	if err != nil {
 		return fmt.Errorf("error attempting to wake %s: %v", hwaddr, err)
 	}
 	return nil
 }
 
+// wakeAndWait wakes the configured server if it is not already ready and
+// waits until it is ready, the configured timeout has elapsed or ctx is
+// cancelled, reporting its progress in p. It returns whether a wake was
+// sent.
+func wakeAndWait(ctx context.Context, c *config, p *phase, info *log.Logger) (woken bool, err error) {
+	chain, err := newWakeChain(c)
+	if err != nil {
+		return false, err
+	}
+	switch c.WakeConfirm {
+	case "":
+	case "inbound":
+		return wakeAndConfirm(ctx, c, chain, p, info)
+	default:
+		return false, fmt.Errorf("invalid wake-confirm: %q", c.WakeConfirm)
+	}
+	var verbose *log.Logger
+	if c.Verbose {
+		verbose = info
+	}
+	probe, err := readinessProbe(c, verbose)
+	if err != nil {
+		return false, err
+	}
+
+	p.begin("checking server")
+	schedule := newPollSchedule(c)
+	deadline, cancel := context.WithTimeout(ctx, time.Duration(c.Timeout))
+	defer cancel()
+	var (
+		sent     bool
+		wakeTime time.Time
+		lastWake time.Time
+		failures int
+		polls    int
+	)
+	for {
+		if err := deadline.Err(); err != nil {
+			if err != context.DeadlineExceeded {
+				p.set("cancelled")
+				return sent, err
+			}
+			p.set("timed out")
+			return sent, fmt.Errorf("timed out waiting for %s", c.Server)
+		}
+		err := probe()
+		if err == nil {
+			break
+		}
+		if c.Verbose {
+			info.Printf("server not ready: %v", err)
+		}
+		if unreachable(err) {
+			failures++
+			if c.MaxConsecutiveErrors > 0 && failures > c.MaxConsecutiveErrors {
+				p.set("failed")
+				return sent, fmt.Errorf("server never reachable after %d consecutive connection errors: %v", failures, err)
+			}
+		} else {
+			failures = 0
+		}
+		if !sent {
+			if c.Verbose {
+				if warn := routedBroadcastWarning(c); warn != "" {
+					info.Printf("warning: %s", warn)
+				}
+			}
+			info.Printf("sending wake packet for %s", c.Server)
+			p.set("waking server")
+			if err := chain.send(deadline, c, info); err != nil {
+				p.set("failed")
+				return false, err
+			}
+			p.set("waiting for server")
+			sent = true
+			wakeTime = time.Now()
+			lastWake = wakeTime
+		} else if polls++; polls >= c.fallbackPolls() && chain.fallback() {
+			info.Printf("%s not ready after %d probes: falling back to %s wake-mode", c.Server, polls, chain.name())
+			if err := chain.send(deadline, c, info); err != nil && deadline.Err() == nil {
+				p.set("failed")
+				return true, err
+			}
+			polls = 0
+			lastWake = time.Now()
+		} else if c.RepeatInterval > 0 && time.Since(lastWake) >= time.Duration(c.RepeatInterval) {
+			info.Printf("resending wake packet for %s", c.Server)
+			if err := chain.send(deadline, c, info); err != nil && deadline.Err() == nil {
+				p.set("failed")
+				return true, err
+			}
+			lastWake = time.Now()
+		}
+		sleep(deadline, schedule.next(time.Since(wakeTime), err))
+	}
+	if sent {
+		if len(chain.modes) > 1 {
+			info.Printf("%s woken by %s wake-mode", c.Server, chain.name())
+		}
+		p.set("waiting after ready")
+		sleep(ctx, time.Duration(c.Wait))
+		if err := ctx.Err(); err != nil {
+			p.set("cancelled")
+			return sent, err
+		}
+	}
+	p.set("ready")
+	return sent, nil
+}
+
 func main() {
 	genconf := flag.Bool("genconf", false, "generate a configuration file")
 	install := flag.Bool("install", false, "create a symlink to the executable")
+	ensure := flag.Bool("ensure", false, "create the config directory, default configuration and symlink if they do not exist")
+	daemon := flag.Bool("daemon", false, "run continuously, waking the server when the configured network is joined")
+	capabilities := flag.Bool("capabilities", false, "print the supported backends, checks and wake modes")
+	listReasons := flag.Bool("reasons", false, "print the Back In Time callback reason codes")
+	check := flag.Bool("check", false, "check the configuration for errors and likely problems")
+	schedule := flag.Bool("schedule", false, "print the readiness probe schedule for the configuration")
+	var set settings
+	flag.Var(&set, "set", "override a configuration value with `key=value` (may be repeated)")
+	completion := flag.String("completion", "", "print a completion script for the named `shell` (bash, fish or zsh)")
 	help := flag.Bool("help", false, "print this message")
 	flag.Parse()
 	if *help {
-		fmt.Fprintln(os.Stderr, `Usage of bit-user-callback:
+		fmt.Fprint(os.Stderr, `Usage of bit-user-callback:
 
 If invoked by Back In Time, user-callback accepts three or more arguments:
 
 * the profile id (1=Main Profile, ...)
 * the profile name
-* the reason as described at [1]
+* the reason as described at [1] and listed by -reasons
 
-user-callback ignores profile id and only acts for reason 7.
+user-callback only acts for reason 7 and for profiles whose id or name is
+listed in the profile configuration value. The reason may be a space-separated
+list of reasons, in which case user-callback acts if any of them is 7.
 
 Operation of user-callback is configured via a JSON file. A default
 configuration will be written by invoking bit-user-callback with -genconf.
+Invoking bit-user-callback with -ensure creates the configuration directory,
+a default configuration and the symlink if they do not already exist.
+
+If the default-reason configuration value is set, user-callback may be
+invoked without arguments and acts as if invoked for a configured profile
+with that reason.
+
+If invoked with -daemon, user-callback does not expect any arguments and
+runs until terminated, waking the server each time the host joins the
+configured network.
 
 [1]https://github.com/bit-team/user-callback
 `)
 		flag.PrintDefaults()
-		os.Exit(0)
+		exit(0)
+	}
+	if *capabilities {
+		printCapabilities(os.Stdout)
+		exit(0)
+	}
+	if *listReasons {
+		printReasons(os.Stdout)
+		exit(0)
+	}
+	if *completion != "" {
+		err := printCompletion(os.Stdout, *completion, flag.CommandLine)
+		if err != nil {
+			log.Print(err)
+			exit(1)
+		}
+		exit(0)
+	}
+	if *ensure {
+		err := ensureInstalled(os.Stdout)
+		if err != nil {
+			log.Print(err)
+			exit(1)
+		}
+		exit(0)
 	}
 	if *install {
 		installLink()
@@ -290,7 +812,7 @@ configuration will be written by invoking bit-user-callback with -genconf.
 		generateConfig()
 	}
 	if *install || *genconf {
-		os.Exit(0)
+		exit(0)
 	}
 
 	info := log.New(os.Stdout, "user-callback: ", log.LstdFlags)
@@ -298,65 +820,211 @@ configuration will be written by invoking bit-user-callback with -genconf.
 
 	c, err := readConfig()
 	if err != nil {
-		fatal.Fatalf("failed to read config: %v", err)
+		fatal.Printf("failed to read config: %v", err)
+		exit(1)
+	}
+	err = set.apply(c)
+	if err != nil {
+		fatal.Print(err)
+		exit(1)
+	}
+
+	if *check {
+		if !checkConfig(c, os.Stdout) {
+			exit(1)
+		}
+		fmt.Println("configuration ok")
+		exit(0)
+	}
+	if *schedule {
+		err := printSchedule(c, os.Stdout)
+		if err != nil {
+			fatal.Print(err)
+			exit(1)
+		}
+		exit(0)
+	}
+
+	if c.ExitDelay > 0 {
+		// Registered first so that it is run last, after
+		// all other cleanups have completed.
+		atExit(func() { time.Sleep(time.Duration(c.ExitDelay)) })
 	}
 
-	var f *os.File
 	if c.LogFile != "" {
-		f, err = os.OpenFile(c.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		f, err := os.OpenFile(c.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			source, _ := configPath()
+			if _, ok := os.LookupEnv(envName("logfile")); ok {
+				source = "environment variable " + envName("logfile")
+			}
+			if !c.LogFileOptional {
+				fatal.Printf("failed to open logfile %q set in %s: %v", c.LogFile, source, err)
+				exit(1)
+			}
+			fatal.Printf("failed to open logfile %q set in %s: %v: continuing without logfile", c.LogFile, source, err)
+		} else {
+			atExit(func() {
+				f.Sync()
+				f.Close()
+			})
+			info.SetOutput(io.MultiWriter(os.Stdout, f))
+			fatal.SetOutput(io.MultiWriter(os.Stderr, f))
+		}
+	}
+
+	if c.PIDFile != "" {
+		err = writePIDFile(c.PIDFile)
 		if err != nil {
-			fatal.Fatal(err)
+			fatal.Printf("failed to write pid file: %v", err)
+			exit(1)
 		}
-		defer f.Close()
-		info.SetOutput(io.MultiWriter(os.Stdout, f))
-		fatal.SetOutput(io.MultiWriter(os.Stderr, f))
+	}
+
+	if c.StatusSocket != "" {
+		err = serveStatus(c.StatusSocket)
+		if err != nil {
+			fatal.Printf("failed to open status socket: %v", err)
+			exit(1)
+		}
+	}
+
+	// ctx is cancelled when the program exits, stopping
+	// any wait for the server.
+	ctx, cancel := context.WithCancel(context.Background())
+	atExit(cancel)
+
+	if *daemon {
+		exitOnSignal(0, info)
+		fatal.Print(runDaemon(ctx, c, info, fatal))
+		exit(1)
+	}
+	exitOnSignal(1, info)
+	if c.MaxRuntime > 0 {
+		time.AfterFunc(time.Duration(c.MaxRuntime), func() {
+			fatal.Printf("exceeded max-runtime of %v", time.Duration(c.MaxRuntime))
+			exit(1)
+		})
 	}
 
 	if c.Verbose {
 		info.Printf("received arguments: %q", flag.Args())
 	}
-	if flag.NArg() < 3 {
-		fatal.Fatalf("unexpected number of arguments: want >=3, got %d", flag.NArg())
+	var id, profile, reason string
+	switch {
+	case flag.NArg() >= 3:
+		id, profile, reason = flag.Arg(0), flag.Arg(1), flag.Arg(2)
+	case flag.NArg() == 0 && c.DefaultReason != "":
+		// Not invoked by Back In Time, for example when
+		// started by a systemd unit, so no profile applies.
+		reason = c.DefaultReason
+	default:
+		fatal.Printf("unexpected number of arguments: want >=3, got %d", flag.NArg())
+		exit(1)
+	}
+	if c.LogContext {
+		prefix := fmt.Sprintf("user-callback: [%s/%s] ", profile, reason)
+		info.SetPrefix(prefix)
+		fatal.SetPrefix(prefix)
 	}
-	profile := flag.Args()[1]
-	reason := flag.Args()[2]
-	if profile != c.Profile || reason != mount {
-		return
+	if flag.NArg() != 0 && !(contains(id, c.Profile) || contains(profile, c.Profile)) {
+		exit(0)
 	}
+	// Wrappers that coalesce events may pass several
+	// space-separated reasons. Mounting is the only
+	// reason acted on, so act if it is any of them.
+	if !contains(mount, strings.Fields(reason)) {
+		exit(0)
+	}
+
+	summary.begin()
+	atExit(func() { info.Print(&summary) })
 
-	ssids, err := essids()
+	denied, err := deniedESSID(c)
+	if err != nil {
+		summary.set("error")
+		fatal.Print(err)
+		exit(1)
+	}
+	if denied != "" {
+		summary.set("denied")
+		info.Printf("connected to denied network %q, not waking", denied)
+		exit(1)
+	}
+	networks, err := connectedNetworks(c)
+	if err != nil {
+		summary.set("error")
+		fatal.Print(err)
+		exit(1)
+	}
+	if len(networks) == 0 {
+		summary.set("not-connected")
+		info.Printf("not connected to %s", trustedNetworks(c))
+		exit(1)
+	}
+	err = checkConnectivity(c)
+	if err != nil {
+		summary.set("no-connectivity")
+		fatal.Print(err)
+		exit(1)
+	}
+	metered, err := meteredConnection(c)
 	if err != nil {
-		fatal.Fatal(err)
+		summary.set("error")
+		fatal.Printf("could not determine whether connection is metered: %v", err)
+		exit(1)
 	}
-	if !contains(c.ESSID, ssids) {
-		info.Fatalf("not connected to %q", c.ESSID)
+	if metered {
+		summary.set("metered")
+		info.Print("connection is metered, not waking")
+		exit(exitMetered)
+	}
+	weak, err := weakSignal(networks)
+	if err != nil {
+		summary.set("error")
+		fatal.Printf("could not determine signal level: %v", err)
+		exit(1)
+	}
+	if weak != "" {
+		summary.set("weak-signal")
+		info.Printf("signal too weak, not waking: %s", weak)
+		exit(c.weakSignalExit())
 	}
 
-	start := time.Now()
-	var sent bool
-	for {
-		if time.Since(start) > time.Duration(c.Timeout) {
-			fatal.Fatalf("timed out waiting for %s", c.Server)
-		}
-		resp, err := http.Get(c.Server)
-		if err == nil {
-			resp.Body.Close()
-			if resp.StatusCode == 200 {
-				break
-			}
+	var results []targetResult
+	if recentlyReady(c, info) && probeTargets(networks) == nil {
+		info.Print("server recently ready, not waking")
+	} else {
+		results, err = wakeAll(ctx, c, networks, info)
+		if err != nil {
+			summary.set("failed")
+			recordRun(c, false, results, fatal)
+			reportFailure(c, fatal, err)
+			fatal.Print(err)
+			exit(1)
 		}
-		if !sent {
-			info.Print("sending wake packet")
-			err = wake(c.MAC, c.Local, c.Remote)
-			if err != nil {
-				fatal.Fatal(err)
+	}
+	summary.set("ready")
+	info.Print("server ready")
+	recordRun(c, true, results, fatal)
+	sdNotify("READY=1")
+	runReadyHook(c, anyWoken(results), info, fatal)
+
+	if len(c.Backup) != 0 {
+		info.Printf("running backup command %q", c.Backup)
+		err = runWithRetries(c, "backup command", c.Backup, info, fatal)
+		if err != nil {
+			var exitErr *exec.ExitError
+			summary.setBackup("failed")
+			if errors.As(err, &exitErr) {
+				fatal.Printf("backup command failed: %v", err)
+				exit(exitErr.ExitCode())
 			}
-			sent = true
+			fatal.Printf("could not run backup command: %v", err)
+			exit(1)
 		}
-		time.Sleep(time.Duration(c.Delay))
+		summary.setBackup("ok")
+		info.Print("backup complete")
 	}
-	if sent {
-		time.Sleep(time.Duration(c.Wait))
-	}
-	info.Print("server ready")
+	exit(0)
 }
@@ -0,0 +1,77 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var durationTests = []struct {
+	data    string
+	want    time.Duration
+	wantErr bool
+}{
+	{data: `null`, want: 0},
+	{data: `"2m"`, want: 2 * time.Minute},
+	{data: `"1m30s"`, want: 90 * time.Second},
+	{data: `120`, want: 120 * time.Second},
+	{data: `"120"`, want: 120 * time.Second},
+	{data: `1.5`, want: 1500 * time.Millisecond},
+	{data: `"1.5"`, want: 1500 * time.Millisecond},
+	{data: `2e1`, want: 20 * time.Second},
+	{data: `"2e1"`, want: 20 * time.Second},
+	{data: `-1`, want: -time.Second},
+	{data: `"two minutes"`, wantErr: true},
+	{data: `"1.5.2"`, wantErr: true},
+	{data: `"NaN"`, wantErr: true},
+	{data: `1e300`, wantErr: true},
+	{data: `""`, wantErr: true},
+	{data: `true`, wantErr: true},
+}
+
+func TestDurationUnmarshalJSON(t *testing.T) {
+	for _, test := range durationTests {
+		var got duration
+		err := got.UnmarshalJSON([]byte(test.data))
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for %s: got:%v want error:%t", test.data, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if time.Duration(got) != test.want {
+			t.Errorf("unexpected duration for %s: got:%v want:%v", test.data, time.Duration(got), test.want)
+		}
+	}
+}
+
+func TestDurationInConfig(t *testing.T) {
+	var c struct {
+		Delay duration `json:"delay"`
+	}
+	err := json.Unmarshal([]byte(`{"delay": 1.5}`), &c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Duration(c.Delay) != 1500*time.Millisecond {
+		t.Errorf("unexpected duration: got:%v want:%v", time.Duration(c.Delay), 1500*time.Millisecond)
+	}
+	err = json.Unmarshal([]byte(`{"delay": "soon"}`), &c)
+	if err == nil {
+		t.Error("expected error for malformed duration")
+	}
+}
+
+func TestWakeInvalidMAC(t *testing.T) {
+	for _, mac := range []string{"", "not-a-mac", "01:23:45:67:89"} {
+		err := wake(&config{}, "udp4", mac, "", "127.0.0.1:9")
+		if err == nil {
+			t.Errorf("expected error for MAC address %q", mac)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	defaultEssidBackend = "auto"
+	defaultServerCheck  = "http"
+	defaultWakeMode     = "udp"
+)
+
+// capability describes a configurable method and the external programs
+// it requires.
+type capability struct {
+	desc     string
+	requires []string
+}
+
+// essidBackend is a method for determining the ESSIDs of the networks
+// the host is connected to.
+type essidBackend struct {
+	capability
+	essids func(c *config) ([]string, error)
+	// connections returns the connected wireless
+	// interfaces and their ESSIDs. It is nil if
+	// the backend cannot determine interfaces.
+	connections func(c *config) ([]connection, error)
+}
+
+// essidBackends are the valid essid-backend configuration values.
+var essidBackends = map[string]essidBackend{
+	"auto": {
+		capability:  capability{desc: "use the first available of nl80211, iwconfig, nmcli and dbus"},
+		essids:      autoESSIDs,
+		connections: autoConnections,
+	},
+	"iwd": {
+		capability: capability{
+			desc:     "query iwd over D-Bus using busctl",
+			requires: []string{"busctl"},
+		},
+		essids:      iwdESSIDs,
+		connections: iwdConnections,
+	},
+	"iwconfig": {
+		capability: capability{
+			desc:     "parse the output of iwconfig",
+			requires: []string{"iwconfig"},
+		},
+		essids:      iwconfigESSIDs,
+		connections: iwconfigConnections,
+	},
+	"command": {
+		capability: capability{desc: "run connectivity-command, matching output lines or using its exit status"},
+		essids:     commandESSIDs,
+	},
+	"dbus": {
+		capability: capability{
+			desc:     "query NetworkManager over D-Bus using busctl, supports connection-uuid",
+			requires: []string{"busctl"},
+		},
+		essids:      dbusESSIDs,
+		connections: dbusConnections,
+	},
+	"nl80211": {
+		capability:  capability{desc: "query the kernel over nl80211 netlink, falling back to iwconfig if nl80211 is unavailable"},
+		essids:      nl80211ESSIDs,
+		connections: nl80211Connections,
+	},
+	"nmcli": {
+		capability: capability{
+			desc:     "query NetworkManager using nmcli, supports connection-uuid",
+			requires: []string{"nmcli"},
+		},
+		essids:      nmcliESSIDs,
+		connections: nmcliConnections,
+	},
+	"wext": {
+		capability:  capability{desc: "query each wireless interface using wireless extensions ioctls"},
+		essids:      wextESSIDs,
+		connections: wextConnections,
+	},
+	"wpa": {
+		capability:  capability{desc: "query the wpa_supplicant control interface of each wireless interface"},
+		essids:      wpaESSIDs,
+		connections: wpaConnections,
+	},
+}
+
+// serverCheck is a method for determining whether the server is ready.
+type serverCheck struct {
+	capability
+	// probe returns a function that returns a nil
+	// error when the check passes.
+	probe func(c *config, chk check) (func() error, error)
+}
+
+// serverChecks are the valid server-check configuration values.
+var serverChecks = map[string]serverCheck{
+	// The all and any checks are built by checkProbe
+	// since they are made from other server checks.
+	"all": {
+		capability: capability{desc: "all of checks pass"},
+	},
+	"any": {
+		capability: capability{desc: "any of checks passes"},
+	},
+	"command": {
+		capability: capability{desc: "command exits with status zero"},
+		probe:      commandProbe,
+	},
+	"dns": {
+		capability: capability{desc: "address resolves, optionally to the expected IP address"},
+		probe:      dnsProbe,
+	},
+	"grpc": {
+		capability: capability{desc: "grpc.health.v1 Health/Check of service at address reports SERVING"},
+		probe:      grpcProbe,
+	},
+	"http": {
+		capability: capability{desc: "HTTP request to server returns an acceptable status"},
+		probe:      httpProbe,
+	},
+	"icmp": {
+		capability: capability{desc: "address, or the server host, answers ICMP echo requests"},
+		probe:      icmpProbe,
+	},
+	"nfs": {
+		capability: capability{
+			desc:     "address, or the server host, exports share over NFS",
+			requires: []string{"showmount"},
+		},
+		probe: nfsProbe,
+	},
+	"rsync": {
+		capability: capability{desc: "rsync daemon at address, or the server host, accepts a request for module"},
+		probe:      rsyncProbe,
+	},
+	"smb": {
+		capability: capability{
+			desc:     "SMB connection to share on address, or the server host, succeeds",
+			requires: []string{"smbclient"},
+		},
+		probe: smbProbe,
+	},
+	"ssh": {
+		capability: capability{
+			desc:     "ssh login to address, or the server host, succeeds and runs command",
+			requires: []string{"ssh"},
+		},
+		probe: sshProbe,
+	},
+	"tailscale": {
+		capability: capability{
+			desc:     "server, named by address or tailscale-peer, is online in the tailnet",
+			requires: []string{"tailscale"},
+		},
+		probe: tailscaleProbe,
+	},
+	"tcp": {
+		capability: capability{desc: "TCP connection to address (host:port), or to the server host and port, succeeds"},
+		probe:      tcpProbe,
+	},
+}
+
+// wakeMode is a method for waking the server.
+type wakeMode struct {
+	capability
+	wake func(c *config) error
+}
+
+// wakeModes are the valid wake-mode configuration values.
+var wakeModes = map[string]wakeMode{
+	"amt": {
+		capability: capability{desc: "Intel AMT WS-Management power on request sent to amt-host"},
+		wake:       wakeAMT,
+	},
+	"azure": {
+		capability: capability{
+			desc:     "start the Azure virtual machine azure-vm using the az command line tool",
+			requires: []string{"az"},
+		},
+		wake: wakeAzure,
+	},
+	"ec2": {
+		capability: capability{
+			desc:     "start the EC2 instance ec2-instance using the aws command line tool",
+			requires: []string{"aws"},
+		},
+		wake: wakeEC2,
+	},
+	"gce": {
+		capability: capability{
+			desc:     "start the Compute Engine instance gce-instance using the gcloud command line tool",
+			requires: []string{"gcloud"},
+		},
+		wake: wakeGCE,
+	},
+	"http": {
+		capability: capability{desc: "HTTP request to webhook-url, for example to trigger a home automation wake"},
+		wake:       wakeWebhook,
+	},
+	"ipmi": {
+		capability: capability{
+			desc:     "IPMI chassis power on sent to the BMC at ipmi-host using ipmitool",
+			requires: []string{"ipmitool"},
+		},
+		wake: wakeIPMI,
+	},
+	"libvirt": {
+		capability: capability{
+			desc:     "start the libvirt domain libvirt-domain using virsh",
+			requires: []string{"virsh"},
+		},
+		wake: wakeLibvirt,
+	},
+	"mqtt": {
+		capability: capability{desc: "publish mqtt-payload to mqtt-topic on mqtt-broker, for example to switch on a smart plug"},
+		wake:       wakeMQTT,
+	},
+	"proxmox": {
+		capability: capability{desc: "start the Proxmox VE guest proxmox-vmid using the Proxmox API"},
+		wake:       wakeProxmox,
+	},
+	"raw": {
+		capability: capability{desc: "Wake-On-LAN magic packet sent as a raw ethernet frame on wake-interface, requires CAP_NET_RAW"},
+		wake:       wakeRaw,
+	},
+	"redfish": {
+		capability: capability{desc: "Redfish ComputerSystem.Reset On request sent to the BMC at redfish-url"},
+		wake:       wakeRedfish,
+	},
+	"snmp": {
+		capability: capability{
+			desc:     "set snmp-oid on the PDU at snmp-host to snmp-value using snmpset",
+			requires: []string{"snmpset"},
+		},
+		wake: wakeSNMP,
+	},
+	"ssh": {
+		capability: capability{
+			desc:     "run ssh-relay-command on ssh-relay using ssh",
+			requires: []string{"ssh"},
+		},
+		wake: wakeSSH,
+	},
+	"tailscale": {
+		capability: capability{
+			desc:     "run tailscale-relay-command on tailscale-relay using tailscale ssh",
+			requires: []string{"tailscale"},
+		},
+		wake: wakeTailscale,
+	},
+	"udp": {
+		capability: capability{desc: "Wake-On-LAN magic packet sent over UDP"},
+		wake:       wakeUDP,
+	},
+}
+
+// essids returns the ESSIDs of the networks the host is connected to
+// using the configured essid-backend.
+func essids(c *config) ([]string, error) {
+	b, err := lookupEssidBackend(c.EssidBackend)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Interfaces) != 0 {
+		if b.connections == nil {
+			return nil, fmt.Errorf("interfaces requires an essid-backend that reports interfaces")
+		}
+		conns, err := b.connections(c)
+		return connectionESSIDs(c.allowedConnections(conns)), err
+	}
+	return b.essids(c)
+}
+
+// lookupEssidBackend returns the named ESSID backend, or the default backend
+// if name is empty.
+func lookupEssidBackend(name string) (essidBackend, error) {
+	if name == "" {
+		name = defaultEssidBackend
+	}
+	b, ok := essidBackends[name]
+	if !ok {
+		return essidBackend{}, fmt.Errorf("unknown essid-backend: %q", name)
+	}
+	return b, nil
+}
+
+// lookupServerCheck returns the named server check, or the default check
+// if name is empty.
+func lookupServerCheck(name string) (serverCheck, error) {
+	if name == "" {
+		name = defaultServerCheck
+	}
+	s, ok := serverChecks[name]
+	if !ok {
+		return serverCheck{}, fmt.Errorf("unknown server-check: %q", name)
+	}
+	return s, nil
+}
+
+// lookupWakeMode returns the named wake mode, or the default mode if name
+// is empty.
+func lookupWakeMode(name string) (wakeMode, error) {
+	if name == "" {
+		name = defaultWakeMode
+	}
+	m, ok := wakeModes[name]
+	if !ok {
+		return wakeMode{}, fmt.Errorf("unknown wake-mode: %q", name)
+	}
+	return m, nil
+}
+
+// printCapabilities writes the valid essid-backend, server-check and
+// wake-mode values to w, noting the external programs each requires and
+// whether they are available.
+func printCapabilities(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	section := func(key, def string, caps map[string]capability) {
+		fmt.Fprintf(tw, "%s (default %q):\n", key, def)
+		names := make([]string, 0, len(caps))
+		for n := range caps {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintf(tw, "\t%s\t%s\t%s\n", n, caps[n].desc, requirements(caps[n].requires))
+		}
+	}
+
+	caps := make(map[string]capability)
+	for n, b := range essidBackends {
+		caps[n] = b.capability
+	}
+	section("essid-backend", defaultEssidBackend, caps)
+
+	caps = make(map[string]capability)
+	for n, s := range serverChecks {
+		caps[n] = s.capability
+	}
+	section("server-check", defaultServerCheck, caps)
+
+	caps = make(map[string]capability)
+	for n, m := range wakeModes {
+		caps[n] = m.capability
+	}
+	section("wake-mode", defaultWakeMode, caps)
+
+	tw.Flush()
+}
+
+// requirements returns a description of the required programs and whether
+// they can be found in $PATH.
+func requirements(progs []string) string {
+	if len(progs) == 0 {
+		return "no external requirements"
+	}
+	var buf strings.Builder
+	buf.WriteString("requires ")
+	for i, p := range progs {
+		if i != 0 {
+			buf.WriteString(", ")
+		}
+		path, err := exec.LookPath(p)
+		if err != nil {
+			fmt.Fprintf(&buf, "%s (not found)", p)
+		} else {
+			fmt.Fprintf(&buf, "%s (found at %s)", p, path)
+		}
+	}
+	return buf.String()
+}
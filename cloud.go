@@ -0,0 +1,56 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "errors"
+
+// wakeEC2 starts the configured EC2 instance using the aws command line
+// tool, which obtains credentials from the standard AWS credential chain.
+// Starting an instance that is already running has no effect.
+func wakeEC2(c *config) error {
+	if c.EC2Instance == "" {
+		return errors.New("ec2 wake-mode requires ec2-instance")
+	}
+	args := []string{"ec2", "start-instances", "--instance-ids", c.EC2Instance, "--output", "json"}
+	if c.EC2Region != "" {
+		args = append(args, "--region", c.EC2Region)
+	}
+	if c.EC2Profile != "" {
+		args = append(args, "--profile", c.EC2Profile)
+	}
+	_, err := outputLines(toolCommand("aws", args...))
+	return err
+}
+
+// wakeGCE starts the configured Google Compute Engine instance using the
+// gcloud command line tool and its configured credentials.
+func wakeGCE(c *config) error {
+	if c.GCEInstance == "" {
+		return errors.New("gce wake-mode requires gce-instance")
+	}
+	args := []string{"compute", "instances", "start", c.GCEInstance, "--quiet"}
+	if c.GCEZone != "" {
+		args = append(args, "--zone", c.GCEZone)
+	}
+	if c.GCEProject != "" {
+		args = append(args, "--project", c.GCEProject)
+	}
+	_, err := outputLines(toolCommand("gcloud", args...))
+	return err
+}
+
+// wakeAzure starts the configured Azure virtual machine using the az
+// command line tool and its logged in account.
+func wakeAzure(c *config) error {
+	if c.AzureVM == "" || c.AzureResourceGroup == "" {
+		return errors.New("azure wake-mode requires azure-vm and azure-resource-group")
+	}
+	args := []string{"vm", "start", "--name", c.AzureVM, "--resource-group", c.AzureResourceGroup}
+	if c.AzureSubscription != "" {
+		args = append(args, "--subscription", c.AzureSubscription)
+	}
+	_, err := outputLines(toolCommand("az", args...))
+	return err
+}
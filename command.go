@@ -0,0 +1,129 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// runCommand runs the command described by argv with its standard output
+// and standard error written to the outputs of the info and fatal loggers.
+func runCommand(argv []string, info, fatal *log.Logger) error {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout = info.Writer()
+	cmd.Stderr = fatal.Writer()
+	return cmd.Run()
+}
+
+// commandLines runs the command described by argv and returns the non-empty
+// lines of its standard output. If the command fails, the returned error
+// includes the command's standard error output.
+func commandLines(argv []string) ([]string, error) {
+	return outputLines(exec.Command(argv[0], argv[1:]...))
+}
+
+// secret returns the first line of the output of the command described by
+// argv, if it is not empty, so that credentials may be obtained from a
+// password manager or keyring such as secret-tool. Otherwise it returns
+// value.
+func secret(value string, argv []string) (string, error) {
+	if len(argv) == 0 {
+		return value, nil
+	}
+	lines, err := commandLines(argv)
+	if err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("%s: no secret output", argv[0])
+	}
+	return lines[0], nil
+}
+
+// toolCommand returns a command to run the named system tool with the given
+// arguments in the C locale, so that its output can be parsed reliably
+// regardless of the user's locale.
+func toolCommand(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	return cmd
+}
+
+// outputLines runs cmd and returns the non-empty lines of its standard
+// output. If the command fails, the returned error includes the command's
+// standard error output.
+func outputLines(cmd *exec.Cmd) ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		if stderr.Len() != 0 {
+			return nil, fmt.Errorf("%s: %v: %s", cmd.Args[0], err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return nil, fmt.Errorf("%s: %v", cmd.Args[0], err)
+	}
+	var lines []string
+	sc := bufio.NewScanner(&stdout)
+	for sc.Scan() {
+		if l := sc.Text(); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, sc.Err()
+}
+
+// runReadyHook runs the configured on-ready-command if the server was woken,
+// or the on-already-ready-command if the server was ready before any wake was
+// sent. Failure of the hook is logged but is not otherwise acted on.
+func runReadyHook(c *config, woken bool, info, fatal *log.Logger) {
+	name := "on-ready-command"
+	argv := c.OnReady
+	if !woken {
+		name = "on-already-ready-command"
+		argv = c.OnAlreadyReady
+	}
+	if len(argv) == 0 {
+		return
+	}
+	if c.Verbose {
+		info.Printf("running %s %q", name, argv)
+	}
+	err := runWithRetries(c, name, argv, info, fatal)
+	if err != nil {
+		fatal.Printf("%s failed: %v", name, err)
+	}
+}
+
+// runWithRetries runs the command described by argv, retrying it up to the
+// configured number of command retries with the configured delay between
+// attempts if it fails. The error from the last attempt is returned.
+func runWithRetries(c *config, name string, argv []string, info, fatal *log.Logger) error {
+	attempts := 1 + c.CommandRetries
+	var err error
+	for i := 1; i <= attempts; i++ {
+		err = runCommand(argv, info, fatal)
+		if err == nil {
+			if i > 1 {
+				info.Printf("%s succeeded on attempt %d of %d", name, i, attempts)
+			}
+			return nil
+		}
+		if i < attempts {
+			fatal.Printf("%s failed on attempt %d of %d: %v: retrying in %v", name, i, attempts, err, time.Duration(c.CommandRetryDelay))
+			time.Sleep(time.Duration(c.CommandRetryDelay))
+		}
+	}
+	if attempts > 1 {
+		return fmt.Errorf("failed after %d attempts: %w", attempts, err)
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestToolCommandEnv(t *testing.T) {
+	const (
+		key = "BIT_USER_CALLBACK_TEST"
+		val = "caller"
+	)
+	for k, v := range map[string]string{key: val, "LC_ALL": "fr_FR.UTF-8"} {
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, v)
+		if ok {
+			defer os.Setenv(k, old)
+		} else {
+			defer os.Unsetenv(k)
+		}
+	}
+
+	cmd := toolCommand("sh", "-c", "echo $LC_ALL $"+key)
+
+	var lcAll string
+	var kept bool
+	for _, e := range cmd.Env {
+		if strings.HasPrefix(e, "LC_ALL=") {
+			// The last value for a key is the one used.
+			lcAll = e
+		}
+		kept = kept || e == key+"="+val
+	}
+	if lcAll != "LC_ALL=C" {
+		t.Errorf("unexpected locale: got:%q want:%q", lcAll, "LC_ALL=C")
+	}
+	if !kept {
+		t.Errorf("caller environment not kept: missing %s=%s", key, val)
+	}
+
+	lines, err := outputLines(cmd)
+	if err != nil {
+		t.Skipf("could not run sh: %v", err)
+	}
+	want := "C " + val
+	if len(lines) != 1 || lines[0] != want {
+		t.Errorf("unexpected command environment: got:%q want:%q", lines, want)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// completionShells are the shells for which completion scripts
+// can be generated.
+var completionShells = []string{"bash", "fish", "zsh"}
+
+// flagValues holds functions returning the completion candidates for the
+// values of flags that take a value.
+var flagValues = map[string]func() []string{
+	"completion": func() []string { return completionShells },
+	"set": func() []string {
+		var keys []string
+		for key := range configFields(&config{}) {
+			keys = append(keys, key+"=")
+		}
+		sort.Strings(keys)
+		return keys
+	},
+}
+
+// completionFlag is a command line flag described for shell completion.
+type completionFlag struct {
+	name   string
+	usage  string
+	values []string
+	isBool bool
+}
+
+// completionFlags returns the flags of fs with their completion candidates.
+func completionFlags(fs *flag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		_, usage := flag.UnquoteUsage(f)
+		cf := completionFlag{name: f.Name, usage: usage}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			cf.isBool = true
+		}
+		if values, ok := flagValues[f.Name]; ok {
+			cf.values = values()
+		}
+		flags = append(flags, cf)
+	})
+	return flags
+}
+
+// printCompletion writes a completion script for the named shell covering
+// the flags of fs to w.
+func printCompletion(w io.Writer, shell string, fs *flag.FlagSet) error {
+	flags := completionFlags(fs)
+	switch shell {
+	case "bash":
+		var names []string
+		for _, f := range flags {
+			names = append(names, "-"+f.name)
+		}
+		fmt.Fprintln(w, "_bit_user_callback() {")
+		fmt.Fprintln(w, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"")
+		fmt.Fprintln(w, "\tcase \"$prev\" in")
+		for _, f := range flags {
+			if f.isBool {
+				continue
+			}
+			fmt.Fprintf(w, "\t-%s|--%[1]s)\n", f.name)
+			if len(f.values) != 0 && strings.HasSuffix(f.values[0], "=") {
+				fmt.Fprintln(w, "\t\tcompopt -o nospace")
+			}
+			fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(f.values, " "))
+			fmt.Fprintln(w, "\t\treturn;;")
+		}
+		fmt.Fprintln(w, "\tesac")
+		fmt.Fprintf(w, "\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+		fmt.Fprintln(w, "}")
+		fmt.Fprintln(w, "complete -F _bit_user_callback bit-user-callback")
+	case "fish":
+		for _, f := range flags {
+			fmt.Fprintf(w, "complete -c bit-user-callback -o %s", f.name)
+			if !f.isBool {
+				fmt.Fprintf(w, " -x -a %s", fishQuote(strings.Join(f.values, " ")))
+			}
+			fmt.Fprintf(w, " -d %s\n", fishQuote(f.usage))
+		}
+	case "zsh":
+		fmt.Fprintln(w, "#compdef bit-user-callback")
+		fmt.Fprintln(w, "_arguments \\")
+		for i, f := range flags {
+			spec := fmt.Sprintf("-%s[%s]", f.name, zshEscape(f.usage))
+			if f.name == "set" {
+				spec = "*" + spec
+			}
+			if !f.isBool {
+				spec += fmt.Sprintf(":%s:(%s)", f.name, strings.Join(f.values, " "))
+			}
+			sep := " \\"
+			if i == len(flags)-1 {
+				sep = ""
+			}
+			fmt.Fprintf(w, "\t'%s'%s\n", strings.ReplaceAll(spec, "'", `'\''`), sep)
+		}
+	default:
+		return fmt.Errorf("unsupported shell %q: must be one of %s", shell, strings.Join(completionShells, ", "))
+	}
+	return nil
+}
+
+// fishQuote returns s quoted for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// zshEscape returns s with characters that are special in _arguments
+// option descriptions escaped.
+func zshEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
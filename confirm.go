@@ -0,0 +1,137 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// wakeAndConfirm wakes the configured server and waits until the server
+// confirms that it is awake, the configured timeout has elapsed or ctx is
+// cancelled. The server confirms the wake by sending a UDP datagram or an
+// HTTP request to the wake-confirm-listen address. Only messages from an
+// address of the configured server are accepted. Progress is reported in
+// p. It returns whether a wake was sent.
+func wakeAndConfirm(ctx context.Context, c *config, chain *wakeChain, p *phase, info *log.Logger) (woken bool, err error) {
+	if c.ConfirmListen == "" {
+		return false, errors.New("inbound wake-confirm requires wake-confirm-listen")
+	}
+	ips, err := serverIPs(c)
+	if err != nil {
+		return false, err
+	}
+	confirmed := make(chan net.IP, 1)
+	accept := func(ip net.IP) bool {
+		for _, allowed := range ips {
+			if allowed.Equal(ip) {
+				select {
+				case confirmed <- ip:
+				default:
+				}
+				return true
+			}
+		}
+		return false
+	}
+
+	pc, err := net.ListenPacket("udp", c.ConfirmListen)
+	if err != nil {
+		return false, err
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			_, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			accept(addr.(*net.UDPAddr).IP)
+		}
+	}()
+	l, err := net.Listen("tcp", c.ConfirmListen)
+	if err != nil {
+		return false, err
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if !accept(net.ParseIP(host)) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	p.begin("waking server")
+	info.Printf("sending wake packet for %s", c.Server)
+	err = chain.send(ctx, c, info)
+	if err != nil {
+		p.set("failed")
+		return false, err
+	}
+	p.set("waiting for confirmation")
+	deadline, cancel := context.WithTimeout(ctx, time.Duration(c.Timeout))
+	defer cancel()
+	// A nil channel never receives, so packets are only
+	// resent when wake-repeat-interval is set.
+	var resend <-chan time.Time
+	if c.RepeatInterval > 0 {
+		t := time.NewTicker(time.Duration(c.RepeatInterval))
+		defer t.Stop()
+		resend = t.C
+	}
+wait:
+	for {
+		select {
+		case ip := <-confirmed:
+			info.Printf("wake confirmed by %s", ip)
+			break wait
+		case <-resend:
+			info.Printf("resending wake packet for %s", c.Server)
+			err = chain.send(deadline, c, info)
+			if err != nil && deadline.Err() == nil {
+				p.set("failed")
+				return true, err
+			}
+		case <-deadline.Done():
+			if err := deadline.Err(); err != context.DeadlineExceeded {
+				p.set("cancelled")
+				return true, err
+			}
+			p.set("timed out")
+			return true, fmt.Errorf("timed out waiting for wake confirmation from %s", c.Server)
+		}
+	}
+
+	p.set("waiting after ready")
+	sleep(ctx, time.Duration(c.Wait))
+	if err := ctx.Err(); err != nil {
+		p.set("cancelled")
+		return true, err
+	}
+	p.set("ready")
+	return true, nil
+}
+
+// serverIPs returns the IP addresses of the host of the configured server.
+func serverIPs(c *config) ([]net.IP, error) {
+	u, err := url.Parse(c.Server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server: %v", err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid server: no host in %q", c.Server)
+	}
+	return lookupIP(c, u.Hostname())
+}
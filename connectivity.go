@@ -0,0 +1,60 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// connectivityURL is the default URL requested by the http connectivity
+// check. It responds with 204 No Content when not intercepted.
+const connectivityURL = "http://connectivitycheck.gstatic.com/generate_204"
+
+// checkConnectivity returns an error if the configured connectivity check
+// finds that the host does not have working network connectivity, for
+// example because it is held at a captive portal. If no connectivity check
+// is configured, it returns nil.
+func checkConnectivity(c *config) error {
+	switch c.ConnectivityCheck {
+	case "":
+		return nil
+	case "http":
+		url := c.ConnectivityURL
+		if url == "" {
+			url = connectivityURL
+		}
+		client := &http.Client{
+			Timeout: c.probeTimeout(),
+			// A captive portal will typically redirect
+			// the request, so report the redirect.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		resp, err := client.Get(url)
+		if err != nil {
+			return fmt.Errorf("connectivity check failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("connectivity check failed: %s returned %s, possibly a captive portal", url, resp.Status)
+		}
+		return nil
+	case "nmcli":
+		lines, err := nmcli(c, "networking", "connectivity", "check")
+		if err != nil {
+			return fmt.Errorf("connectivity check failed: %v", err)
+		}
+		state := strings.Join(lines, " ")
+		if state != "full" {
+			return fmt.Errorf("connectivity check failed: NetworkManager reports %s connectivity", state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid connectivity-check: %q", c.ConnectivityCheck)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// settle is the time to wait after a network change for the
+// system to finish configuring the interface before checking
+// the connected network.
+const settle = 500 * time.Millisecond
+
+// recheck is the interval at which the checks made before waking are
+// repeated while the host is on the configured network but a check has
+// failed, for example until the user has logged in at a captive portal.
+const recheck = 30 * time.Second
+
+// runDaemon waits for network link and address changes and wakes the
+// server each time the host joins the configured network. If the checks
+// made before waking fail, they are repeated on the next change or after
+// the recheck interval. It only returns if the network change events can
+// no longer be received.
+func runDaemon(ctx context.Context, c *config, info, fatal *log.Logger) error {
+	events, err := listenLinkEvents()
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+	sdNotify("READY=1")
+
+	var (
+		connected bool
+
+		// blocked is the failure of the checks made
+		// before waking while on the configured network.
+		blocked string
+	)
+	for {
+		networks, err := connectedNetworks(c)
+		if err != nil {
+			info.Printf("failed to get connected networks: %v", err)
+		}
+		now := len(networks) != 0
+		if !now {
+			blocked = ""
+		}
+		if now && !connected {
+			if blocked == "" {
+				info.Printf("connected to %s", describeNetworks(networks, " and "))
+			}
+			err = wakeAllowed(c, networks)
+			if err != nil {
+				// Treat the network as not connected so that the
+				// checks are repeated on the next change or after
+				// the recheck interval. A repeated failure is only
+				// logged once.
+				if err.Error() != blocked {
+					fatal.Print(err)
+				}
+				now = false
+				blocked = err.Error()
+			} else {
+				blocked = ""
+				results, err := wakeAll(ctx, c, networks, info)
+				recordRun(c, err == nil, results, fatal)
+				if err != nil {
+					fatal.Print(err)
+					reportFailure(c, fatal, err)
+				} else {
+					info.Print("server ready")
+					runReadyHook(c, anyWoken(results), info, fatal)
+				}
+			}
+		}
+		connected = now
+
+		var timeout time.Duration
+		if blocked != "" {
+			progress.begin("waiting to recheck network")
+			timeout = recheck
+		} else {
+			progress.begin("waiting for network change")
+		}
+		err = events.Wait(settle, timeout)
+		if err != nil {
+			return err
+		}
+	}
+}
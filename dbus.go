@@ -0,0 +1,136 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// NetworkManager D-Bus names.
+const (
+	nmService      = "org.freedesktop.NetworkManager"
+	nmPath         = "/org/freedesktop/NetworkManager"
+	nmActive       = "org.freedesktop.NetworkManager.Connection.Active"
+	nmDevice       = "org.freedesktop.NetworkManager.Device"
+	nmAccessPoint  = "org.freedesktop.NetworkManager.AccessPoint"
+	nmWirelessType = "802-11-wireless"
+)
+
+// dbusESSIDs returns the ESSIDs of wireless networks that the host is
+// connected to by querying NetworkManager over D-Bus.
+func dbusESSIDs(c *config) ([]string, error) {
+	conns, err := dbusConnections(c)
+	return connectionESSIDs(conns), err
+}
+
+// dbusConnections returns the wireless interfaces that the host is
+// connected to and their ESSIDs, access points and signal levels by
+// querying NetworkManager over D-Bus.
+func dbusConnections(c *config) ([]connection, error) {
+	active, err := dbusActiveConnections()
+	if err != nil {
+		return nil, err
+	}
+	var conns []connection
+	for _, a := range active {
+		if a.typ != nmWirelessType || a.accessPoint == "/" || a.accessPoint == "" {
+			continue
+		}
+		// The SSID is an array of bytes which busctl
+		// renders as an array of JSON numbers.
+		var (
+			octets   []int
+			bssid    string
+			strength int
+		)
+		err = busctlProperties(a.accessPoint, nmAccessPoint, []string{"Ssid", "HwAddress", "Strength"}, &octets, &bssid, &strength)
+		if err != nil {
+			return nil, err
+		}
+		ssid := make([]byte, len(octets))
+		for i, o := range octets {
+			ssid[i] = byte(o)
+		}
+		var iface string
+		if len(a.devices) != 0 {
+			err = busctlProperties(a.devices[0], nmDevice, []string{"Interface"}, &iface)
+			if err != nil {
+				return nil, err
+			}
+		}
+		conns = append(conns, connection{essid: string(ssid), iface: iface, bssid: bssid, signal: percentToDBm(strength)})
+	}
+	return conns, nil
+}
+
+// dbusActiveUUIDs returns the UUIDs of the active NetworkManager
+// connections by querying NetworkManager over D-Bus.
+func dbusActiveUUIDs() ([]string, error) {
+	active, err := dbusActiveConnections()
+	if err != nil {
+		return nil, err
+	}
+	uuids := make([]string, len(active))
+	for i, a := range active {
+		uuids[i] = a.uuid
+	}
+	return uuids, nil
+}
+
+// activeConnection is an active NetworkManager connection.
+type activeConnection struct {
+	typ         string
+	uuid        string
+	devices     []string
+	accessPoint string
+}
+
+// dbusActiveConnections returns the active NetworkManager connections.
+func dbusActiveConnections() ([]activeConnection, error) {
+	var paths []string
+	err := busctlProperties(nmPath, nmService, []string{"ActiveConnections"}, &paths)
+	if err != nil {
+		return nil, err
+	}
+	active := make([]activeConnection, len(paths))
+	for i, p := range paths {
+		a := &active[i]
+		err = busctlProperties(p, nmActive,
+			[]string{"Type", "Uuid", "Devices", "SpecificObject"},
+			&a.typ, &a.uuid, &a.devices, &a.accessPoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return active, nil
+}
+
+// busctlProperties gets the named properties of the NetworkManager object
+// at path with the given interface using busctl, storing the value of each
+// in the corresponding element of dst.
+func busctlProperties(path, iface string, props []string, dst ...interface{}) error {
+	args := append([]string{"--system", "--json=short", "get-property", nmService, path, iface}, props...)
+	lines, err := outputLines(toolCommand("busctl", args...))
+	if err != nil {
+		return err
+	}
+	if len(lines) != len(props) {
+		return fmt.Errorf("busctl: unexpected output for %s properties of %s: %q", iface, path, lines)
+	}
+	for i, l := range lines {
+		var v struct {
+			Data json.RawMessage `json:"data"`
+		}
+		err = json.Unmarshal([]byte(l), &v)
+		if err == nil {
+			err = json.Unmarshal(v.Data, dst[i])
+		}
+		if err != nil {
+			return fmt.Errorf("busctl: invalid %s property of %s: %v", props[i], path, err)
+		}
+	}
+	return nil
+}
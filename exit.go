@@ -0,0 +1,102 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// exitMu serializes calls to exit.
+	exitMu sync.Mutex
+
+	// cleanups are the functions to call before exiting.
+	cleanups []func()
+
+	// sendMu protects exiting. sends counts the webhook
+	// requests and emails being sent, which exit waits
+	// for before calling the cleanup functions.
+	sendMu  sync.Mutex
+	exiting bool
+	sends   sync.WaitGroup
+)
+
+// sendWait is the longest exit waits for webhook
+// requests and emails that are being sent.
+const sendWait = 10 * time.Second
+
+// atExit registers f to be called by exit.
+func atExit(f func()) {
+	exitMu.Lock()
+	cleanups = append(cleanups, f)
+	exitMu.Unlock()
+}
+
+// sending records the start of a webhook request or email send that exit
+// must wait for. The returned function must be called when the send is
+// complete.
+func sending() (done func()) {
+	sendMu.Lock()
+	defer sendMu.Unlock()
+	if exiting {
+		return func() {}
+	}
+	sends.Add(1)
+	return sends.Done
+}
+
+// exit waits for up to sendWait for webhook requests and emails that are
+// being sent to complete, calls the functions registered by atExit in the
+// reverse order of their registration and then terminates the program with
+// the given status code. Concurrent calls to exit after the first block until
+// the program exits.
+func exit(code int) {
+	exitMu.Lock()
+	sendMu.Lock()
+	exiting = true
+	sendMu.Unlock()
+	sent := make(chan struct{})
+	go func() {
+		sends.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(sendWait):
+	}
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	os.Exit(code)
+}
+
+// exitOnSignal arranges for exit to be called with the given status code when
+// the program receives an interrupt or termination signal.
+func exitOnSignal(code int, info *log.Logger) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		info.Printf("received %v", <-sig)
+		exit(code)
+	}()
+}
+
+// writePIDFile writes the process ID to the file at path and arranges for the
+// file to be removed by exit.
+func writePIDFile(path string) error {
+	err := ioutil.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+	if err != nil {
+		return err
+	}
+	atExit(func() { os.Remove(path) })
+	return nil
+}
@@ -0,0 +1,280 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// grpcProbe returns a readiness probe that succeeds when the gRPC service at
+// the check's address reports that the check's service, or the server as a
+// whole if no service is given, is SERVING using the grpc.health.v1
+// Health/Check RPC. The RPC is made over TLS if the check's tls field is set.
+// The check's address must include a port since there is no default gRPC
+// port to fall back to.
+func grpcProbe(c *config, chk check) (func() error, error) {
+	addr := chk.Address
+	if addr == "" {
+		return nil, errors.New("grpc check requires an address with a port")
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("grpc check requires an address with a port: %v", err)
+	}
+	authority := chk.Authority
+	if authority == "" {
+		authority = addr
+	}
+	var tlsConfig *tls.Config
+	if chk.TLS || chk.Fingerprint != "" {
+		tlsConfig = &tls.Config{ServerName: host}
+		if chk.Fingerprint != "" {
+			tlsConfig, err = pinnedTLSConfig("grpc server", chk.Fingerprint)
+			if err != nil {
+				return nil, err
+			}
+		}
+		tlsConfig.NextProtos = []string{"h2"}
+	}
+	return func() error {
+		d := net.Dialer{Timeout: chk.timeout(c), Resolver: c.resolver()}
+		conn, err := d.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(chk.timeout(c)))
+		scheme := "http"
+		if tlsConfig != nil {
+			tc := tls.Client(conn, tlsConfig)
+			err = tc.Handshake()
+			if err != nil {
+				return err
+			}
+			if p := tc.ConnectionState().NegotiatedProtocol; p != "h2" {
+				return fmt.Errorf("server does not support HTTP/2: negotiated %q", p)
+			}
+			conn = tc
+			scheme = "https"
+		}
+		return grpcHealthCheck(conn, scheme, authority, chk.Service)
+	}, nil
+}
+
+// HTTP/2 frame types and flags used by grpcHealthCheck.
+const (
+	h2Data         = 0x0
+	h2Headers      = 0x1
+	h2RSTStream    = 0x3
+	h2Settings     = 0x4
+	h2Ping         = 0x6
+	h2GoAway       = 0x7
+	h2WindowUpdate = 0x8
+
+	h2FlagEndStream  = 0x1
+	h2FlagAck        = 0x1
+	h2FlagEndHeaders = 0x4
+	h2FlagPadded     = 0x8
+)
+
+// grpcServing is the grpc.health.v1 SERVING status.
+const grpcServing = 1
+
+// grpcHealthCheck makes a grpc.health.v1 Health/Check RPC for service on
+// the HTTP/2 connection conn, returning a nil error if the reported status
+// is SERVING. Only the response message is examined, so a response without
+// a message, as sent when the RPC fails, is reported as an error without
+// its gRPC status.
+func grpcHealthCheck(conn io.ReadWriter, scheme, authority, service string) error {
+	w := bufio.NewWriter(conn)
+	w.WriteString("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	writeH2Frame(w, h2Settings, 0, 0, nil)
+
+	// The request headers are encoded with HPACK static
+	// table indexes and literals without indexing.
+	var hdr []byte
+	hdr = append(hdr, 0x83) // :method: POST
+	if scheme == "https" {
+		hdr = append(hdr, 0x87) // :scheme: https
+	} else {
+		hdr = append(hdr, 0x86) // :scheme: http
+	}
+	hdr = hpackLiteral(hdr, 4, "", "/grpc.health.v1.Health/Check") // :path
+	hdr = hpackLiteral(hdr, 1, "", authority)                      // :authority
+	hdr = hpackLiteral(hdr, 31, "", "application/grpc")            // content-type
+	hdr = hpackLiteral(hdr, 0, "te", "trailers")
+	writeH2Frame(w, h2Headers, h2FlagEndHeaders, 1, hdr)
+
+	// HealthCheckRequest has the service name as field 1.
+	var req []byte
+	if service != "" {
+		req = append(req, 0x0a)
+		req = appendVarint(req, uint64(len(service)))
+		req = append(req, service...)
+	}
+	msg := make([]byte, 5, 5+len(req))
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(req)))
+	writeH2Frame(w, h2Data, h2FlagEndStream, 1, append(msg, req...))
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	r := bufio.NewReader(conn)
+	var head [9]byte
+	for {
+		_, err = io.ReadFull(r, head[:])
+		if err != nil {
+			return fmt.Errorf("no health check response: %v", err)
+		}
+		n := int(head[0])<<16 | int(head[1])<<8 | int(head[2])
+		typ, flags := head[3], head[4]
+		stream := binary.BigEndian.Uint32(head[5:]) & 0x7fffffff
+		if n > 1<<14 {
+			return fmt.Errorf("invalid HTTP/2 frame of %d bytes: server may not support HTTP/2", n)
+		}
+		payload := make([]byte, n)
+		_, err = io.ReadFull(r, payload)
+		if err != nil {
+			return fmt.Errorf("no health check response: %v", err)
+		}
+		switch typ {
+		case h2Settings:
+			if flags&h2FlagAck == 0 {
+				writeH2Frame(w, h2Settings, h2FlagAck, 0, nil)
+				err = w.Flush()
+			}
+		case h2Ping:
+			if flags&h2FlagAck == 0 {
+				writeH2Frame(w, h2Ping, h2FlagAck, 0, payload)
+				err = w.Flush()
+			}
+		case h2GoAway:
+			if len(payload) >= 8 {
+				return fmt.Errorf("server closed the connection: error code %d", binary.BigEndian.Uint32(payload[4:]))
+			}
+			return errors.New("server closed the connection")
+		case h2RSTStream:
+			if stream == 1 && len(payload) >= 4 {
+				return fmt.Errorf("server reset the health check: error code %d", binary.BigEndian.Uint32(payload))
+			}
+		case h2Data:
+			if stream != 1 {
+				break
+			}
+			if flags&h2FlagPadded != 0 && len(payload) != 0 {
+				pad := int(payload[0])
+				if pad >= len(payload) {
+					return errors.New("invalid HTTP/2 padding")
+				}
+				payload = payload[1 : len(payload)-pad]
+			}
+			body = append(body, payload...)
+			if len(body) >= 5 {
+				size := int(binary.BigEndian.Uint32(body[1:]))
+				if len(body) >= 5+size {
+					return grpcHealthStatus(body[5 : 5+size])
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if stream == 1 && flags&h2FlagEndStream != 0 && (typ == h2Headers || typ == h2Data) {
+			return errors.New("health check failed: no response message")
+		}
+	}
+}
+
+// grpcHealthStatus returns a nil error if the HealthCheckResponse message
+// m reports the SERVING status.
+func grpcHealthStatus(m []byte) error {
+	status := uint64(0)
+	for len(m) != 0 {
+		key, n := binary.Uvarint(m)
+		if n <= 0 {
+			return errors.New("invalid health check response")
+		}
+		m = m[n:]
+		switch key & 0x7 {
+		case 0:
+			v, n := binary.Uvarint(m)
+			if n <= 0 {
+				return errors.New("invalid health check response")
+			}
+			m = m[n:]
+			if key>>3 == 1 {
+				status = v
+			}
+		case 2:
+			l, n := binary.Uvarint(m)
+			if n <= 0 || uint64(len(m)-n) < l {
+				return errors.New("invalid health check response")
+			}
+			m = m[n+int(l):]
+		default:
+			return errors.New("invalid health check response")
+		}
+	}
+	if status != grpcServing {
+		names := []string{"UNKNOWN", "SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}
+		if status < uint64(len(names)) {
+			return fmt.Errorf("health status %s", names[status])
+		}
+		return fmt.Errorf("health status %d", status)
+	}
+	return nil
+}
+
+// writeH2Frame writes an HTTP/2 frame to w.
+func writeH2Frame(w *bufio.Writer, typ, flags byte, stream uint32, payload []byte) {
+	n := len(payload)
+	w.Write([]byte{byte(n >> 16), byte(n >> 8), byte(n), typ, flags})
+	binary.Write(w, binary.BigEndian, stream)
+	w.Write(payload)
+}
+
+// hpackLiteral appends an HPACK literal header field without indexing to
+// dst. If index is not zero, the field name is the static table entry with
+// that index, otherwise it is name.
+func hpackLiteral(dst []byte, index int, name, value string) []byte {
+	dst = hpackInt(dst, 0x00, 4, index)
+	if index == 0 {
+		dst = hpackInt(dst, 0x00, 7, len(name))
+		dst = append(dst, name...)
+	}
+	dst = hpackInt(dst, 0x00, 7, len(value))
+	return append(dst, value...)
+}
+
+// hpackInt appends the HPACK integer encoding of v with an n-bit prefix
+// to dst, with flags in the high bits of the first byte.
+func hpackInt(dst []byte, flags byte, n uint, v int) []byte {
+	max := 1<<n - 1
+	if v < max {
+		return append(dst, flags|byte(v))
+	}
+	dst = append(dst, flags|byte(max))
+	v -= max
+	for v >= 0x80 {
+		dst = append(dst, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+// appendVarint appends the protobuf varint encoding of v to dst.
+func appendVarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(dst, buf[:binary.PutUvarint(buf[:], v)]...)
+}
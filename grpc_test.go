@@ -0,0 +1,304 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+var hpackIntTests = []struct {
+	flags byte
+	n     uint
+	v     int
+
+	want []byte
+}{
+	// Examples from RFC 7541 appendix C.1.
+	{n: 5, v: 10, want: []byte{0x0a}},
+	{n: 5, v: 1337, want: []byte{0x1f, 0x9a, 0x0a}},
+	{n: 8, v: 42, want: []byte{0x2a}},
+
+	{n: 4, v: 14, want: []byte{0x0e}},
+	{n: 4, v: 15, want: []byte{0x0f, 0x00}},
+	{n: 4, v: 16, want: []byte{0x0f, 0x01}},
+	{n: 4, v: 15 + 127, want: []byte{0x0f, 0x7f}},
+	{n: 4, v: 15 + 128, want: []byte{0x0f, 0x80, 0x01}},
+	{n: 7, v: 126, want: []byte{0x7e}},
+	{n: 7, v: 127, want: []byte{0x7f, 0x00}},
+	{n: 7, v: 255, want: []byte{0x7f, 0x80, 0x01}},
+	{flags: 0x80, n: 7, v: 10, want: []byte{0x8a}},
+	{flags: 0x80, n: 7, v: 127, want: []byte{0xff, 0x00}},
+}
+
+func TestHpackInt(t *testing.T) {
+	for _, test := range hpackIntTests {
+		got := hpackInt(nil, test.flags, test.n, test.v)
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("unexpected encoding of %d with %d-bit prefix and flags %#x: got:%#x want:%#x",
+				test.v, test.n, test.flags, got, test.want)
+		}
+	}
+}
+
+var grpcHealthStatusTests = []struct {
+	name string
+	msg  []byte
+
+	wantErr string
+}{
+	{name: "serving", msg: []byte{0x08, 0x01}},
+	{name: "unknown field", msg: []byte{0x12, 0x01, 'x', 0x08, 0x01}},
+	{name: "empty", msg: nil, wantErr: "health status UNKNOWN"},
+	{name: "not serving", msg: []byte{0x08, 0x02}, wantErr: "health status NOT_SERVING"},
+	{name: "service unknown", msg: []byte{0x08, 0x03}, wantErr: "health status SERVICE_UNKNOWN"},
+	{name: "out of range", msg: []byte{0x08, 0x07}, wantErr: "health status 7"},
+	{name: "missing value", msg: []byte{0x08}, wantErr: "invalid health check response"},
+	{name: "truncated value", msg: []byte{0x08, 0x81}, wantErr: "invalid health check response"},
+	{name: "truncated key", msg: []byte{0x88}, wantErr: "invalid health check response"},
+	{name: "truncated length", msg: []byte{0x12, 0x80}, wantErr: "invalid health check response"},
+	{name: "short field", msg: []byte{0x12, 0x05, 'x'}, wantErr: "invalid health check response"},
+	{name: "overflow", msg: []byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, wantErr: "invalid health check response"},
+	{name: "fixed32", msg: []byte{0x0d, 0x01, 0x00, 0x00, 0x00}, wantErr: "invalid health check response"},
+}
+
+func TestGRPCHealthStatus(t *testing.T) {
+	for _, test := range grpcHealthStatusTests {
+		err := grpcHealthStatus(test.msg)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %s: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.wantErr {
+			t.Errorf("unexpected error for %s: got:%v want:%q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestGRPCProbeAddress(t *testing.T) {
+	c := &config{Server: "http://storage.lan/"}
+	for _, addr := range []string{"", "storage.lan", "[::1"} {
+		_, err := grpcProbe(c, check{Type: "grpc", Address: addr})
+		if err == nil || !strings.Contains(err.Error(), "grpc check requires an address with a port") {
+			t.Errorf("unexpected error for address %q: got:%v", addr, err)
+		}
+	}
+	_, err := grpcProbe(c, check{Type: "grpc", Address: "storage.lan:8443"})
+	if err != nil {
+		t.Errorf("unexpected error for address with port: %v", err)
+	}
+}
+
+// h2Frame is an HTTP/2 frame sent or received by the fake gRPC server.
+type h2Frame struct {
+	typ, flags byte
+	stream     uint32
+	payload    []byte
+}
+
+// h2Frames returns the wire encoding of frames.
+func h2Frames(frames ...h2Frame) []byte {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	for _, f := range frames {
+		writeH2Frame(w, f.typ, f.flags, f.stream, f.payload)
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// grpcMessage returns msg with gRPC length-prefixed message framing.
+func grpcMessage(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+var (
+	// h2ServerSettings is the empty server connection preface.
+	h2ServerSettings = h2Frame{typ: h2Settings}
+
+	// h2ResponseHeaders holds the :status 200 response headers.
+	h2ResponseHeaders = h2Frame{typ: h2Headers, flags: h2FlagEndHeaders, stream: 1, payload: []byte{0x88}}
+
+	// h2Trailers holds a grpc-status trailer. It is
+	// not decoded by the client so its encoding is
+	// not checked.
+	h2Trailers = h2Frame{typ: h2Headers, flags: h2FlagEndHeaders | h2FlagEndStream, stream: 1, payload: []byte("grpc-status: 0")}
+)
+
+var grpcHealthCheckTests = []struct {
+	name     string
+	response []byte
+
+	wantErr string
+}{
+	{
+		name: "serving",
+		response: h2Frames(
+			h2ServerSettings,
+			h2ResponseHeaders,
+			h2Frame{typ: h2Data, stream: 1, payload: grpcMessage([]byte{0x08, 0x01})},
+			h2Trailers,
+		),
+	},
+	{
+		name: "serving split padded",
+		response: h2Frames(
+			h2ServerSettings,
+			h2Frame{typ: h2Ping, payload: make([]byte, 8)},
+			h2ResponseHeaders,
+			h2Frame{typ: h2Data, flags: h2FlagPadded, stream: 1, payload: append([]byte{2}, append(grpcMessage([]byte{0x08, 0x01})[:3], 0, 0)...)},
+			h2Frame{typ: h2Data, stream: 1, payload: grpcMessage([]byte{0x08, 0x01})[3:]},
+			h2Trailers,
+		),
+	},
+	{
+		name: "not serving",
+		response: h2Frames(
+			h2ServerSettings,
+			h2ResponseHeaders,
+			h2Frame{typ: h2Data, stream: 1, payload: grpcMessage([]byte{0x08, 0x02})},
+			h2Trailers,
+		),
+		wantErr: "health status NOT_SERVING",
+	},
+	{
+		name: "rst stream",
+		response: h2Frames(
+			h2ServerSettings,
+			h2Frame{typ: h2RSTStream, stream: 1, payload: []byte{0, 0, 0, 7}},
+		),
+		wantErr: "server reset the health check: error code 7",
+	},
+	{
+		name: "trailers only",
+		response: h2Frames(
+			h2ServerSettings,
+			h2Frame{typ: h2Headers, flags: h2FlagEndHeaders | h2FlagEndStream, stream: 1, payload: []byte{0x88}},
+		),
+		wantErr: "health check failed: no response message",
+	},
+	{
+		name: "go away",
+		response: h2Frames(
+			h2ServerSettings,
+			h2Frame{typ: h2GoAway, payload: []byte{0, 0, 0, 0, 0, 0, 0, 1}},
+		),
+		wantErr: "server closed the connection: error code 1",
+	},
+	{
+		name:     "not http2",
+		response: []byte("HTTP/1.1 400 Bad Request\r\n\r\n"),
+		wantErr:  "server may not support HTTP/2",
+	},
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	const (
+		authority = "storage.lan:8443"
+		service   = "storage.v1.Store"
+	)
+	wantRequest := grpcMessage(append([]byte{0x0a, byte(len(service))}, service...))
+
+	for _, test := range grpcHealthCheckTests {
+		client, server := net.Pipe()
+		done := make(chan error, 1)
+		var headers, request []byte
+		go func(response []byte) {
+			var err error
+			headers, request, err = fakeGRPCServer(server, response)
+			done <- err
+		}(test.response)
+
+		// The server is left open until the client is
+		// finished since the client may still be writing
+		// acknowledgements after the response is read.
+		err := grpcHealthCheck(client, "https", authority, service)
+		client.Close()
+		serr := <-done
+		server.Close()
+		if serr != nil {
+			t.Errorf("unexpected server error for %s: %v", test.name, serr)
+			continue
+		}
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %s: %v", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("unexpected error for %s: got:%v want:%q", test.name, err, test.wantErr)
+		}
+
+		for _, want := range [][]byte{
+			{0x83, 0x87}, // :method: POST, :scheme: https
+			[]byte("/grpc.health.v1.Health/Check"),
+			[]byte(authority),
+			[]byte("application/grpc"),
+		} {
+			if !bytes.Contains(headers, want) {
+				t.Errorf("request headers for %s missing %q: %q", test.name, want, headers)
+			}
+		}
+		if !bytes.Equal(request, wantRequest) {
+			t.Errorf("unexpected request for %s: got:%#x want:%#x", test.name, request, wantRequest)
+		}
+	}
+}
+
+// fakeGRPCServer reads a health check request from conn, returning its
+// header block and message after writing response. Frames sent by the
+// client after the request are discarded.
+func fakeGRPCServer(conn net.Conn, response []byte) (headers, request []byte, err error) {
+	const preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	b := make([]byte, len(preface))
+	_, err = io.ReadFull(conn, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(b) != preface {
+		return nil, nil, errors.New("invalid client preface")
+	}
+	for {
+		var head [9]byte
+		_, err = io.ReadFull(conn, head[:])
+		if err != nil {
+			return nil, nil, err
+		}
+		payload := make([]byte, int(head[0])<<16|int(head[1])<<8|int(head[2]))
+		_, err = io.ReadFull(conn, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		typ, flags, stream := head[3], head[4], binary.BigEndian.Uint32(head[5:])
+		if stream != 1 {
+			continue
+		}
+		switch typ {
+		case h2Headers:
+			headers = append(headers, payload...)
+		case h2Data:
+			request = append(request, payload...)
+		}
+		if flags&h2FlagEndStream != 0 {
+			break
+		}
+	}
+	go io.Copy(ioutil.Discard, conn)
+	// The write fails if the client stops reading
+	// before the end of the response, so any error
+	// is ignored.
+	conn.Write(response)
+	return headers, request, nil
+}
@@ -0,0 +1,203 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	// defaultPingCount is the default number of echo
+	// requests sent by an icmp check.
+	defaultPingCount = 3
+
+	// pingInterval is the time between the echo
+	// requests sent by an icmp check.
+	pingInterval = 200 * time.Millisecond
+)
+
+// icmpProbe returns a readiness probe that succeeds when the check's
+// address, or the host of the configured server if no address is given,
+// answers ICMP echo requests. The check's count echo requests are sent, and
+// the check succeeds if the proportion lost is no more than the check's
+// max-loss percentage or, if max-loss is not set, if any reply is received.
+func icmpProbe(c *config, chk check) (func() error, error) {
+	host, err := chk.host(c)
+	if err != nil {
+		return nil, err
+	}
+	count := chk.Count
+	if count < 1 {
+		count = defaultPingCount
+	}
+	if chk.MaxLoss != nil && (*chk.MaxLoss < 0 || 100 < *chk.MaxLoss) {
+		return nil, fmt.Errorf("invalid max-loss: %v", *chk.MaxLoss)
+	}
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), chk.timeout(c))
+		defer cancel()
+		ips, err := c.resolver().LookupIPAddr(ctx, host)
+		if err != nil {
+			return err
+		}
+		ip := ips[0].IP
+		for _, a := range ips {
+			if a.IP.To4() != nil {
+				ip = a.IP
+				break
+			}
+		}
+		received, err := ping(ctx, ip, count)
+		if err != nil {
+			return err
+		}
+		loss := 100 * float64(count-received) / float64(count)
+		switch {
+		case chk.MaxLoss != nil && loss > *chk.MaxLoss:
+			return fmt.Errorf("%d of %d echo replies received from %s: %.0f%% loss", received, count, ip, loss)
+		case received == 0:
+			return fmt.Errorf("no echo replies received from %s", ip)
+		}
+		return nil
+	}, nil
+}
+
+// ping sends count ICMP echo requests to ip and returns the number of
+// replies received before ctx is done or all replies have been received.
+func ping(ctx context.Context, ip net.IP, count int) (int, error) {
+	conn, dst, raw, err := listenICMP(ip)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	request, reply := byte(8), byte(0)
+	if ip.To4() == nil {
+		request, reply = 128, 129
+	}
+	id := uint16(os.Getpid())
+	sent := make(chan error, 1)
+	go func() {
+		defer close(sent)
+		for seq := 0; seq < count; seq++ {
+			if seq != 0 {
+				sleep(ctx, pingInterval)
+				if ctx.Err() != nil {
+					return
+				}
+			}
+			_, err := conn.WriteTo(echoRequest(request, id, uint16(seq)), dst)
+			if err != nil {
+				sent <- err
+				return
+			}
+		}
+	}()
+
+	seen := make(map[uint16]bool)
+	buf := make([]byte, 1500)
+	for len(seen) < count {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				break
+			}
+			return len(seen), err
+		}
+		if n < 8 || buf[0] != reply || !addrIP(from).Equal(ip) {
+			continue
+		}
+		// Unprivileged ping sockets replace the identifier
+		// with their own, and only deliver their replies.
+		if raw && binary.BigEndian.Uint16(buf[4:]) != id {
+			continue
+		}
+		seq := binary.BigEndian.Uint16(buf[6:])
+		if int(seq) < count {
+			seen[seq] = true
+		}
+	}
+	if len(seen) == 0 {
+		if err := <-sent; err != nil {
+			return 0, err
+		}
+	}
+	return len(seen), nil
+}
+
+// listenICMP returns a connection for sending ICMP echo requests to ip and
+// the destination address to send them to. It uses a raw socket if the
+// process is permitted to open one, and an unprivileged ping socket if not.
+// The returned raw value is whether a raw socket is used.
+func listenICMP(ip net.IP) (conn net.PacketConn, dst net.Addr, raw bool, err error) {
+	network, laddr := "ip4:icmp", "0.0.0.0"
+	if ip.To4() == nil {
+		network, laddr = "ip6:ipv6-icmp", "::"
+	}
+	conn, err = net.ListenPacket(network, laddr)
+	if err == nil {
+		return conn, &net.IPAddr{IP: ip}, true, nil
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		return nil, nil, false, err
+	}
+	conn, perr := listenPing(ip)
+	if perr != nil {
+		return nil, nil, false, fmt.Errorf("%v; unprivileged ping: %v", err, perr)
+	}
+	return conn, &net.UDPAddr{IP: ip}, false, nil
+}
+
+// echoRequest returns an ICMP echo request message of the given type with
+// the given identifier and sequence number.
+func echoRequest(typ byte, id, seq uint16) []byte {
+	b := make([]byte, 8, 8+len("bit-user-callback"))
+	b[0] = typ
+	binary.BigEndian.PutUint16(b[4:], id)
+	binary.BigEndian.PutUint16(b[6:], seq)
+	b = append(b, "bit-user-callback"...)
+	if typ == 8 {
+		// The ICMPv6 checksum includes a pseudo-header
+		// and is calculated by the kernel.
+		binary.BigEndian.PutUint16(b[2:], icmpChecksum(b))
+	}
+	return b
+}
+
+// icmpChecksum returns the internet checksum of b.
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 != 0 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// addrIP returns the IP address of addr.
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.IPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	default:
+		return nil
+	}
+}
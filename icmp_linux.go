@@ -0,0 +1,35 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenPing returns an unprivileged ICMP datagram socket for sending echo
+// requests to ip. Unprivileged ping sockets are permitted for the groups in
+// the net.ipv4.ping_group_range sysctl.
+func listenPing(ip net.IP) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if ip.To4() == nil {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+		sa = &syscall.SockaddrInet6{}
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	err = syscall.Bind(fd, sa)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(fd), "ping")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
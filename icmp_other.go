@@ -0,0 +1,17 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func listenPing(ip net.IP) (net.PacketConn, error) {
+	return nil, errors.New("not supported on this platform")
+}
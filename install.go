@@ -0,0 +1,80 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ensureInstalled creates the Back In Time config directory, a default
+// configuration file and the symbolic link to the executable, skipping
+// each that already exists. The outcome of each step is written to w.
+// Existing files are never modified.
+func ensureInstalled(w io.Writer) error {
+	dir, err := configDir()
+	if err != nil {
+		return fmt.Errorf("could not determine config directory: %v", err)
+	}
+	if _, err := os.Stat(dir); err == nil {
+		fmt.Fprintf(w, "config directory %q: already exists\n", dir)
+	} else {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("could not create config directory: %v", err)
+		}
+		fmt.Fprintf(w, "config directory %q: created\n", dir)
+	}
+
+	path := filepath.Join(dir, "user-callback.json")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	switch {
+	case os.IsExist(err):
+		fmt.Fprintf(w, "configuration %q: already exists\n", path)
+	case err != nil:
+		return fmt.Errorf("failed to create config file: %v", err)
+	default:
+		b, err := json.MarshalIndent(defaultConfig(), "", "  ")
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to marshal configuration: %v", err)
+		}
+		_, err = f.Write(b)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write configuration: %v", err)
+		}
+		err = f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write configuration: %v", err)
+		}
+		fmt.Fprintf(w, "configuration %q: created\n", path)
+	}
+
+	exe, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return fmt.Errorf("could not determine executable path: %v", err)
+	}
+	link := filepath.Join(dir, "user-callback")
+	target, err := os.Readlink(link)
+	switch {
+	case err == nil && target == exe:
+		fmt.Fprintf(w, "symbolic link %q: already exists\n", link)
+	case err == nil:
+		fmt.Fprintf(w, "symbolic link %q: already exists pointing to %q, left unchanged\n", link, target)
+	case os.IsNotExist(err):
+		err = os.Symlink(exe, link)
+		if err != nil {
+			return fmt.Errorf("could not create symbolic link: %v", err)
+		}
+		fmt.Fprintf(w, "symbolic link %q: created\n", link)
+	default:
+		fmt.Fprintf(w, "user-callback %q: already exists and is not a symbolic link, left unchanged\n", link)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// wakeIPMI powers on the server by issuing an IPMI chassis power on
+// command to its BMC at the configured ipmi-host using ipmitool.
+func wakeIPMI(c *config) error {
+	if c.IPMIHost == "" {
+		return errors.New("ipmi wake-mode requires ipmi-host")
+	}
+	password, err := secret(c.IPMIPassword, c.IPMIPasswordCommand)
+	if err != nil {
+		return fmt.Errorf("could not get ipmi password: %v", err)
+	}
+	iface := c.IPMIInterface
+	if iface == "" {
+		iface = "lanplus"
+	}
+	args := []string{"-I", iface, "-H", c.IPMIHost}
+	if c.IPMIUser != "" {
+		args = append(args, "-U", c.IPMIUser)
+	}
+	// The password is passed in the environment
+	// so that it is not visible in the process list.
+	cmd := toolCommand("ipmitool", append(args, "-E", "chassis", "power", "on")...)
+	cmd.Env = append(cmd.Env, "IPMI_PASSWORD="+password)
+	_, err = outputLines(cmd)
+	return err
+}
@@ -0,0 +1,108 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// iwd D-Bus names.
+const (
+	iwdService = "net.connman.iwd"
+	iwdStation = "net.connman.iwd.Station"
+	iwdDevice  = "net.connman.iwd.Device"
+	iwdNetwork = "net.connman.iwd.Network"
+	iwdBSS     = "net.connman.iwd.BasicServiceSet"
+)
+
+// iwdESSIDs returns the ESSIDs of wireless networks that the host is
+// connected to by querying iwd over D-Bus.
+func iwdESSIDs(c *config) ([]string, error) {
+	conns, err := iwdConnections(c)
+	return connectionESSIDs(conns), err
+}
+
+// iwdConnections returns the wireless interfaces that the host is connected
+// to and their ESSIDs and access points by querying iwd over D-Bus.
+func iwdConnections(c *config) ([]connection, error) {
+	objects, err := iwdObjects()
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(objects))
+	for p := range objects {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var conns []connection
+	for _, p := range paths {
+		station, ok := objects[p][iwdStation]
+		if !ok {
+			continue
+		}
+		var state, network string
+		if station.get("State", &state) != nil || state != "connected" {
+			continue
+		}
+		if station.get("ConnectedNetwork", &network) != nil {
+			continue
+		}
+		var conn connection
+		err = objects[network][iwdNetwork].get("Name", &conn.essid)
+		if err != nil {
+			return nil, fmt.Errorf("iwd: no name for network %s: %v", network, err)
+		}
+		objects[p][iwdDevice].get("Name", &conn.iface)
+		// The connected access point is only
+		// reported by recent versions of iwd.
+		var bss string
+		if station.get("ConnectedAccessPoint", &bss) == nil {
+			objects[bss][iwdBSS].get("Address", &conn.bssid)
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// dbusProperties holds the D-Bus properties of an object
+// interface as rendered in JSON by busctl.
+type dbusProperties map[string]struct {
+	Data json.RawMessage `json:"data"`
+}
+
+// get stores the value of the named property in dst.
+func (p dbusProperties) get(name string, dst interface{}) error {
+	v, ok := p[name]
+	if !ok {
+		return fmt.Errorf("no %s property", name)
+	}
+	return json.Unmarshal(v.Data, dst)
+}
+
+// iwdObjects returns the objects managed by iwd, keyed by object path
+// and interface name.
+func iwdObjects() (map[string]map[string]dbusProperties, error) {
+	lines, err := outputLines(toolCommand("busctl", "--system", "--json=short",
+		"call", iwdService, "/", "org.freedesktop.DBus.ObjectManager", "GetManagedObjects"))
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) != 1 {
+		return nil, fmt.Errorf("busctl: unexpected output for iwd objects: %q", lines)
+	}
+	var reply struct {
+		Data []map[string]map[string]dbusProperties `json:"data"`
+	}
+	err = json.Unmarshal([]byte(lines[0]), &reply)
+	if err != nil {
+		return nil, fmt.Errorf("busctl: invalid iwd objects: %v", err)
+	}
+	if len(reply.Data) != 1 {
+		return nil, fmt.Errorf("busctl: unexpected iwd objects reply")
+	}
+	return reply.Data[0], nil
+}
@@ -0,0 +1,47 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// locationResolved returns whether the configured location-host resolves,
+// using the system resolver, to an address in one of the configured
+// location-subnets. A name that does not resolve is not an error since
+// private names are expected to be unknown away from the trusted network.
+func locationResolved(c *config) (bool, error) {
+	if len(c.LocationSubnets) == 0 {
+		return false, errors.New("location-host requires location-subnets")
+	}
+	subnets := make([]*net.IPNet, len(c.LocationSubnets))
+	for i, s := range c.LocationSubnets {
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return false, fmt.Errorf("invalid location-subnets: %v", err)
+		}
+		subnets[i] = subnet
+	}
+	// The system resolver is used even when dns-server is
+	// set since the answer must depend on the network the
+	// host is connected to.
+	ctx, cancel := context.WithTimeout(context.Background(), c.probeTimeout())
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, c.LocationHost)
+	if err != nil {
+		return false, nil
+	}
+	for _, a := range addrs {
+		for _, subnet := range subnets {
+			if subnet.Contains(a.IP) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,120 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// discoverService returns the address and port of the configured
+// server-service found by browsing for it with avahi-browse. If
+// server-service-name is set, only the service instance with that
+// name is used. IPv4 addresses are preferred.
+func discoverService(c *config) (host, port string, err error) {
+	lines, err := outputLines(toolCommand("avahi-browse", "--parsable", "--resolve", "--terminate", c.ServerService))
+	if err != nil {
+		return "", "", err
+	}
+	for _, l := range lines {
+		// Resolved services are reported as
+		//  =;iface;protocol;name;type;domain;hostname;address;port;txt
+		f := strings.Split(l, ";")
+		if len(f) < 9 || f[0] != "=" {
+			continue
+		}
+		if c.ServerServiceName != "" && avahiUnescape(f[3]) != c.ServerServiceName {
+			continue
+		}
+		if host == "" || f[2] == "IPv4" && strings.Contains(host, ":") {
+			host, port = f[7], f[8]
+		}
+	}
+	if host == "" {
+		name := c.ServerService
+		if c.ServerServiceName != "" {
+			name = strconv.Quote(c.ServerServiceName) + " " + name
+		}
+		return "", "", fmt.Errorf("no %s service found", name)
+	}
+	return host, port, nil
+}
+
+// avahiUnescape returns s with the \DDD decimal and \c escapes
+// used in avahi-browse parsable output removed.
+func avahiUnescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) {
+			n, err := strconv.ParseUint(s[i+1:i+4], 10, 8)
+			if err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		i++
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// discoveredServer returns the server address to use given that the server
+// was discovered at host and port. The host of the configured server is
+// replaced, retaining its scheme, path and any explicit port. If no server is
+// configured, the discovered host and port are returned.
+func discoveredServer(server, host, port string) string {
+	if server == "" {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(server, "://") {
+		u, err := url.Parse(server)
+		if err == nil {
+			if p := u.Port(); p != "" {
+				u.Host = net.JoinHostPort(host, p)
+			} else if strings.Contains(host, ":") {
+				u.Host = "[" + host + "]"
+			} else {
+				u.Host = host
+			}
+			return u.String()
+		}
+	}
+	if _, p, err := net.SplitHostPort(server); err == nil {
+		return net.JoinHostPort(host, p)
+	}
+	return host
+}
+
+// discoveryProbe returns a readiness probe that discovers the server using
+// the configured server-service and then runs the configured checks against
+// the discovered address, logging passing checks to verbose if it is not nil.
+func discoveryProbe(c *config, verbose *log.Logger) func() error {
+	return func() error {
+		host, port, err := discoverService(c)
+		if err != nil {
+			return fmt.Errorf("service discovery failed: %w", err)
+		}
+		found := *c
+		found.Server = discoveredServer(c.Server, host, port)
+		found.ServerService = ""
+		probe, err := readinessProbe(&found, verbose)
+		if err != nil {
+			return err
+		}
+		return probe()
+	}
+}
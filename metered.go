@@ -0,0 +1,78 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// exitMetered is the exit status used when no wake is sent
+// because the connection is metered.
+const exitMetered = 3
+
+// NetworkManager NMMetered values for a metered connection.
+const (
+	nmMeteredYes      = 1
+	nmMeteredGuessYes = 3
+)
+
+// meteredConnection returns whether the host's network connection is
+// metered. If metered-command is set, the connection is metered when the
+// command exits with a zero status. Otherwise NetworkManager's metered state
+// is queried over D-Bus. It returns false if refuse-metered is not set.
+func meteredConnection(c *config) (bool, error) {
+	if !c.RefuseMetered {
+		return false, nil
+	}
+	if len(c.MeteredCommand) != 0 {
+		err := exec.Command(c.MeteredCommand[0], c.MeteredCommand[1:]...).Run()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	var metered uint32
+	err := busctlProperties(nmPath, nmService, []string{"Metered"}, &metered)
+	if err != nil {
+		return false, err
+	}
+	return metered == nmMeteredYes || metered == nmMeteredGuessYes, nil
+}
+
+// wakeAllowed returns an error if the host is connected to a denied ESSID,
+// the configured connectivity check fails, the connection is metered and
+// metered connections are refused, or the signal of a connection to one of
+// networks is weaker than its min-signal.
+func wakeAllowed(c *config, networks []*config) error {
+	denied, err := deniedESSID(c)
+	if err != nil {
+		return err
+	}
+	if denied != "" {
+		return fmt.Errorf("connected to denied network %q, not waking", denied)
+	}
+	err = checkConnectivity(c)
+	if err != nil {
+		return err
+	}
+	metered, err := meteredConnection(c)
+	if err != nil {
+		return fmt.Errorf("could not determine whether connection is metered: %v", err)
+	}
+	if metered {
+		return errors.New("connection is metered, not waking")
+	}
+	weak, err := weakSignal(networks)
+	if err != nil {
+		return fmt.Errorf("could not determine signal level: %v", err)
+	}
+	if weak != "" {
+		return fmt.Errorf("signal too weak, not waking: %s", weak)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// writeMetrics writes the outcome of a run at time now, and the result for
+// each target, to w in the Prometheus text exposition format.
+func writeMetrics(w io.Writer, now time.Time, ready bool, results []targetResult) error {
+	var buf bytes.Buffer
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("bit_user_callback_last_run_timestamp_seconds", "gauge", "Time of the last run in seconds since the Unix epoch.")
+	fmt.Fprintf(&buf, "bit_user_callback_last_run_timestamp_seconds %.3f\n", float64(now.UnixNano())/1e9)
+	metric("bit_user_callback_last_run_ready", "gauge", "Whether the server was ready at the end of the last run.")
+	fmt.Fprintf(&buf, "bit_user_callback_last_run_ready %d\n", boolMetric(ready))
+
+	if len(results) != 0 {
+		metric("bit_user_callback_target_woken", "gauge", "Whether a wake was sent to the target in the last run.")
+		for _, r := range results {
+			fmt.Fprintf(&buf, "bit_user_callback_target_woken%s %d\n", targetLabels(r), boolMetric(r.Woken))
+		}
+		metric("bit_user_callback_target_ready", "gauge", "Whether the target became ready in the last run.")
+		for _, r := range results {
+			fmt.Fprintf(&buf, "bit_user_callback_target_ready%s %d\n", targetLabels(r), boolMetric(r.Ready))
+		}
+		metric("bit_user_callback_target_elapsed_seconds", "gauge", "Time taken to wake and wait for the target in the last run.")
+		for _, r := range results {
+			fmt.Fprintf(&buf, "bit_user_callback_target_elapsed_seconds%s %.3f\n", targetLabels(r), time.Duration(r.Elapsed).Seconds())
+		}
+		metric("bit_user_callback_target_error", "gauge", "Whether waking or waiting for the target failed in the last run.")
+		for _, r := range results {
+			fmt.Fprintf(&buf, "bit_user_callback_target_error%s %d\n", targetLabels(r), boolMetric(r.Error != ""))
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// targetLabels returns the Prometheus labels identifying the target of r.
+func targetLabels(r targetResult) string {
+	return fmt.Sprintf(`{server="%s",wake_mac="%s"}`, labelValue(r.Server), labelValue(r.MAC))
+}
+
+// labelValue returns s escaped for use as a Prometheus label value.
+var labelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
+
+// boolMetric returns the value of a boolean metric.
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeMetricsFile replaces the metrics held in the file at path with the
+// outcome of the current run.
+func writeMetricsFile(path string, ready bool, results []targetResult) error {
+	var buf bytes.Buffer
+	err := writeMetrics(&buf, time.Now(), ready, results)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, buf.Bytes())
+}
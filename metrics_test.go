@@ -0,0 +1,61 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	results := []targetResult{
+		{
+			Server:  "http://nas.local/",
+			MAC:     "01:23:45:67:89:ab",
+			Woken:   true,
+			Ready:   true,
+			Elapsed: duration(64200 * time.Millisecond),
+		},
+		{
+			Server:  `http://backup.local/"q"`,
+			MAC:     "01:23:45:67:89:ac",
+			Woken:   true,
+			Elapsed: duration(5 * time.Minute),
+			Error:   "timed out waiting for http://backup.local/",
+		},
+	}
+	var buf bytes.Buffer
+	err := writeMetrics(&buf, time.Unix(1464710412, 5e8), false, results)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `# HELP bit_user_callback_last_run_timestamp_seconds Time of the last run in seconds since the Unix epoch.
+# TYPE bit_user_callback_last_run_timestamp_seconds gauge
+bit_user_callback_last_run_timestamp_seconds 1464710412.500
+# HELP bit_user_callback_last_run_ready Whether the server was ready at the end of the last run.
+# TYPE bit_user_callback_last_run_ready gauge
+bit_user_callback_last_run_ready 0
+# HELP bit_user_callback_target_woken Whether a wake was sent to the target in the last run.
+# TYPE bit_user_callback_target_woken gauge
+bit_user_callback_target_woken{server="http://nas.local/",wake_mac="01:23:45:67:89:ab"} 1
+bit_user_callback_target_woken{server="http://backup.local/\"q\"",wake_mac="01:23:45:67:89:ac"} 1
+# HELP bit_user_callback_target_ready Whether the target became ready in the last run.
+# TYPE bit_user_callback_target_ready gauge
+bit_user_callback_target_ready{server="http://nas.local/",wake_mac="01:23:45:67:89:ab"} 1
+bit_user_callback_target_ready{server="http://backup.local/\"q\"",wake_mac="01:23:45:67:89:ac"} 0
+# HELP bit_user_callback_target_elapsed_seconds Time taken to wake and wait for the target in the last run.
+# TYPE bit_user_callback_target_elapsed_seconds gauge
+bit_user_callback_target_elapsed_seconds{server="http://nas.local/",wake_mac="01:23:45:67:89:ab"} 64.200
+bit_user_callback_target_elapsed_seconds{server="http://backup.local/\"q\"",wake_mac="01:23:45:67:89:ac"} 300.000
+# HELP bit_user_callback_target_error Whether waking or waiting for the target failed in the last run.
+# TYPE bit_user_callback_target_error gauge
+bit_user_callback_target_error{server="http://nas.local/",wake_mac="01:23:45:67:89:ab"} 0
+bit_user_callback_target_error{server="http://backup.local/\"q\"",wake_mac="01:23:45:67:89:ac"} 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected metrics:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types and flags.
+const (
+	mqttConnect    = 0x10
+	mqttConnAck    = 0x20
+	mqttPublishQoS = 0x32 // PUBLISH with QoS 1.
+	mqttPubAck     = 0x40
+	mqttDisconnect = 0xe0
+
+	mqttUserFlag     = 0x80
+	mqttPasswordFlag = 0x40
+	mqttCleanSession = 0x02
+)
+
+// Default MQTT broker ports.
+const (
+	mqttPort    = "1883"
+	mqttTLSPort = "8883"
+)
+
+// wakeMQTT publishes the configured mqtt-payload, ON by default, to
+// mqtt-topic on the broker at mqtt-broker, for example to switch on a
+// smart plug powering the server. The message is published with QoS 1
+// and the broker's acknowledgement is awaited.
+func wakeMQTT(c *config) error {
+	if c.MQTTBroker == "" || c.MQTTTopic == "" {
+		return errors.New("mqtt wake-mode requires mqtt-broker and mqtt-topic")
+	}
+	password, err := secret(c.MQTTPassword, c.MQTTPasswordCommand)
+	if err != nil {
+		return fmt.Errorf("could not get mqtt password: %v", err)
+	}
+	addr := c.MQTTBroker
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		port := mqttPort
+		if c.MQTTTLS {
+			port = mqttTLSPort
+		}
+		addr = net.JoinHostPort(addr, port)
+	}
+	d := &net.Dialer{Timeout: c.probeTimeout(), Resolver: c.resolver()}
+	var conn net.Conn
+	if c.MQTTTLS {
+		var tlsConfig *tls.Config
+		if c.MQTTFingerprint != "" {
+			tlsConfig, err = pinnedTLSConfig("mqtt broker", c.MQTTFingerprint)
+			if err != nil {
+				return err
+			}
+		}
+		conn, err = tls.DialWithDialer(d, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.probeTimeout()))
+	return publishMQTT(conn, c, password)
+}
+
+// publishMQTT connects to the MQTT broker on conn, publishes the configured
+// payload to the configured topic, waits for the broker's acknowledgement
+// and disconnects.
+func publishMQTT(conn io.ReadWriter, c *config, password string) error {
+	var flags byte = mqttCleanSession
+	var connect bytes.Buffer
+	connect.Write(mqttString("MQTT"))
+	connect.WriteByte(4) // Protocol level 3.1.1.
+	if c.MQTTUser != "" {
+		flags |= mqttUserFlag
+		if password != "" {
+			flags |= mqttPasswordFlag
+		}
+	}
+	connect.WriteByte(flags)
+	connect.Write([]byte{0, 30}) // Keep alive in seconds.
+	connect.Write(mqttString(fmt.Sprintf("bit-user-callback-%d", os.Getpid())))
+	if c.MQTTUser != "" {
+		connect.Write(mqttString(c.MQTTUser))
+		if password != "" {
+			connect.Write(mqttString(password))
+		}
+	}
+	err := writeMQTT(conn, mqttConnect, connect.Bytes())
+	if err != nil {
+		return err
+	}
+	typ, body, err := readMQTT(conn)
+	if err != nil {
+		return fmt.Errorf("mqtt: %v", err)
+	}
+	if typ != mqttConnAck || len(body) != 2 {
+		return fmt.Errorf("mqtt: unexpected reply to connect: %#x", typ)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("mqtt: connection refused with return code %d", body[1])
+	}
+
+	payload := c.MQTTPayload
+	if payload == "" {
+		payload = "ON"
+	}
+	const packetID = 1
+	var publish bytes.Buffer
+	publish.Write(mqttString(c.MQTTTopic))
+	publish.Write([]byte{0, packetID})
+	publish.WriteString(payload)
+	err = writeMQTT(conn, mqttPublishQoS, publish.Bytes())
+	if err != nil {
+		return err
+	}
+	typ, body, err = readMQTT(conn)
+	if err != nil {
+		return fmt.Errorf("mqtt: %v", err)
+	}
+	if typ != mqttPubAck || len(body) != 2 || body[0] != 0 || body[1] != packetID {
+		return fmt.Errorf("mqtt: unexpected reply to publish: %#x", typ)
+	}
+	return writeMQTT(conn, mqttDisconnect, nil)
+}
+
+// mqttString returns s encoded as an MQTT length-prefixed string.
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+// writeMQTT writes an MQTT control packet of the given type and flags
+// with the given body to w.
+func writeMQTT(w io.Writer, typ byte, body []byte) error {
+	b := []byte{typ}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(b, body...))
+	return err
+}
+
+// readMQTT reads an MQTT control packet from r, returning its type
+// and flags, and its body.
+func readMQTT(r io.Reader) (typ byte, body []byte, err error) {
+	var b [1]byte
+	_, err = io.ReadFull(r, b[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	typ = b[0]
+	var n, shift int
+	for {
+		_, err = io.ReadFull(r, b[:])
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b[0]&0x7f) << shift
+		if b[0]&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift > 21 {
+			return 0, nil, errors.New("invalid remaining length")
+		}
+	}
+	body = make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return typ, body, err
+}
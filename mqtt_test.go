@@ -0,0 +1,173 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+var mqttLengthTests = []struct {
+	n    int
+	want []byte
+}{
+	{n: 0, want: []byte{0x00}},
+	{n: 127, want: []byte{0x7f}},
+	{n: 128, want: []byte{0x80, 0x01}},
+	{n: 218, want: []byte{0xda, 0x01}},
+	{n: 16383, want: []byte{0xff, 0x7f}},
+	{n: 16384, want: []byte{0x80, 0x80, 0x01}},
+}
+
+func TestMQTTRemainingLength(t *testing.T) {
+	for _, test := range mqttLengthTests {
+		body := bytes.Repeat([]byte{'x'}, test.n)
+		var buf bytes.Buffer
+		err := writeMQTT(&buf, mqttPublishQoS, body)
+		if err != nil {
+			t.Errorf("unexpected error writing %d byte body: %v", test.n, err)
+			continue
+		}
+		want := append(append([]byte{mqttPublishQoS}, test.want...), body...)
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("unexpected header for %d byte body: got:%#x want:%#x", test.n, buf.Bytes()[:1+len(test.want)], want[:1+len(test.want)])
+		}
+		typ, got, err := readMQTT(&buf)
+		if err != nil {
+			t.Errorf("unexpected error reading %d byte body: %v", test.n, err)
+			continue
+		}
+		if typ != mqttPublishQoS || !bytes.Equal(got, body) {
+			t.Errorf("unexpected packet for %d byte body: got type:%#x length:%d", test.n, typ, len(got))
+		}
+	}
+}
+
+func TestReadMQTTInvalidLength(t *testing.T) {
+	_, _, err := readMQTT(bytes.NewReader([]byte{mqttPubAck, 0x80, 0x80, 0x80, 0x80, 0x01}))
+	if err == nil {
+		t.Error("expected error for five byte remaining length")
+	}
+}
+
+var publishMQTTTests = []struct {
+	name    string
+	connAck []byte
+	pubAck  []byte
+
+	wantErr string
+}{
+	{
+		name:    "accepted",
+		connAck: []byte{mqttConnAck, 2, 0, 0},
+		pubAck:  []byte{mqttPubAck, 2, 0, 1},
+	},
+	{
+		name:    "refused",
+		connAck: []byte{mqttConnAck, 2, 0, 5},
+		wantErr: "connection refused with return code 5",
+	},
+	{
+		name:    "wrong packet id",
+		connAck: []byte{mqttConnAck, 2, 0, 0},
+		pubAck:  []byte{mqttPubAck, 2, 0, 2},
+		wantErr: "unexpected reply to publish",
+	},
+	{
+		name:    "wrong high packet id",
+		connAck: []byte{mqttConnAck, 2, 0, 0},
+		pubAck:  []byte{mqttPubAck, 2, 1, 1},
+		wantErr: "unexpected reply to publish",
+	},
+}
+
+func TestPublishMQTT(t *testing.T) {
+	const (
+		user     = "bit"
+		password = "secret"
+		topic    = "home/nas/power"
+	)
+	// The payload is long enough that the publish remaining
+	// length, 2+14+2+200 = 218, needs two bytes.
+	payload := strings.Repeat("p", 200)
+
+	var connectBody []byte
+	connectBody = append(connectBody, 0, 4, 'M', 'Q', 'T', 'T', 4, 0xc2, 0, 30)
+	connectBody = append(connectBody, mqttString(fmt.Sprintf("bit-user-callback-%d", os.Getpid()))...)
+	connectBody = append(connectBody, mqttString(user)...)
+	connectBody = append(connectBody, mqttString(password)...)
+	wantConnect := append([]byte{0x10, byte(len(connectBody))}, connectBody...)
+
+	wantPublish := []byte{0x32, 0xda, 0x01, 0, 14}
+	wantPublish = append(wantPublish, topic...)
+	wantPublish = append(wantPublish, 0, 1)
+	wantPublish = append(wantPublish, payload...)
+
+	c := &config{MQTTTopic: topic, MQTTPayload: payload, MQTTUser: user}
+	for _, test := range publishMQTTTests {
+		client, broker := net.Pipe()
+		done := make(chan error, 1)
+		go func(connAck, pubAck []byte, wantDisconnect bool) {
+			defer broker.Close()
+			done <- fakeMQTTBroker(broker, wantConnect, connAck, wantPublish, pubAck, wantDisconnect)
+		}(test.connAck, test.pubAck, test.wantErr == "")
+
+		err := publishMQTT(client, c, password)
+		client.Close()
+		if berr := <-done; berr != nil {
+			t.Errorf("unexpected broker error for %s: %v", test.name, berr)
+		}
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %s: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("unexpected error for %s: got:%v want:%q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+// fakeMQTTBroker checks that conn receives the wanted connect and publish
+// packets, replying with connAck and pubAck. If pubAck is nil the exchange
+// ends after the connection acknowledgement, and if wantDisconnect is false
+// it ends after the publish acknowledgement.
+func fakeMQTTBroker(conn net.Conn, wantConnect, connAck, wantPublish, pubAck []byte, wantDisconnect bool) error {
+	expect := func(what string, want []byte) error {
+		got := make([]byte, len(want))
+		_, err := io.ReadFull(conn, got)
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", what, err)
+		}
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("unexpected %s:\ngot: %#x\nwant:%#x", what, got, want)
+		}
+		return nil
+	}
+
+	err := expect("connect", wantConnect)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(connAck)
+	if err != nil || pubAck == nil {
+		return err
+	}
+	err = expect("publish", wantPublish)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(pubAck)
+	if err != nil || !wantDisconnect {
+		return err
+	}
+	return expect("disconnect", []byte{mqttDisconnect, 0})
+}
@@ -0,0 +1,129 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Netlink route multicast groups from linux/rtnetlink.h.
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// linkEvents is a netlink route socket subscribed to link and address
+// change notifications.
+type linkEvents struct {
+	fd  int
+	buf []byte
+}
+
+// listenLinkEvents returns a new linkEvents listening for link and
+// address changes.
+func listenLinkEvents() (*linkEvents, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("could not open netlink socket: %v", err)
+	}
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	})
+	if err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("could not bind netlink socket: %v", err)
+	}
+	return &linkEvents{fd: fd, buf: make([]byte, 1<<16)}, nil
+}
+
+// Wait blocks until a link or address change is received or, if timeout is
+// positive, until timeout has elapsed. After a change it waits for the given
+// settle time and discards any further queued events so that bursts of
+// changes are coalesced into a single return.
+func (l *linkEvents) Wait(settle, timeout time.Duration) error {
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	err := syscall.SetsockoptTimeval(l.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	if err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	for {
+		n, _, err := syscall.Recvfrom(l.fd, l.buf, 0)
+		switch err {
+		case nil:
+		case syscall.EINTR:
+			continue
+		case syscall.EAGAIN:
+			if timeout > 0 {
+				return nil
+			}
+			continue
+		case syscall.ENOBUFS:
+			// Events were dropped, so we cannot know whether
+			// anything relevant changed; report that it may have.
+			n = 0
+		default:
+			return os.NewSyscallError("recvfrom", err)
+		}
+		if n != 0 && !l.relevant(l.buf[:n]) {
+			continue
+		}
+		break
+	}
+
+	time.Sleep(settle)
+	for {
+		_, _, err := syscall.Recvfrom(l.fd, l.buf, syscall.MSG_DONTWAIT)
+		switch err {
+		case nil, syscall.EINTR, syscall.ENOBUFS:
+			continue
+		case syscall.EAGAIN:
+			return nil
+		default:
+			return os.NewSyscallError("recvfrom", err)
+		}
+	}
+}
+
+// relevant returns whether the netlink messages in b include a link or
+// address change. Link messages that only carry wireless extension events,
+// such as scan results, are ignored.
+func (l *linkEvents) relevant(b []byte) bool {
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		return true
+	}
+	for _, m := range msgs {
+		switch m.Header.Type {
+		case syscall.RTM_NEWADDR, syscall.RTM_DELADDR, syscall.RTM_DELLINK:
+			return true
+		case syscall.RTM_NEWLINK:
+			attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+			if err != nil {
+				return true
+			}
+			wireless := false
+			for _, a := range attrs {
+				if a.Attr.Type == syscall.IFLA_WIRELESS {
+					wireless = true
+					break
+				}
+			}
+			if !wireless {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Close closes the underlying netlink socket.
+func (l *linkEvents) Close() error {
+	return syscall.Close(l.fd)
+}
@@ -0,0 +1,22 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+type linkEvents struct{}
+
+func listenLinkEvents() (*linkEvents, error) {
+	return nil, errors.New("network change events not supported on this platform")
+}
+
+func (l *linkEvents) Wait(settle, timeout time.Duration) error { return nil }
+func (l *linkEvents) Close() error                             { return nil }
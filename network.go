@@ -0,0 +1,754 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// onTrustedNetwork returns whether the host is connected to the configured
+// trusted network.
+//
+// If a connection UUID is configured, the host is on the trusted network when
+// the NetworkManager connection with that UUID is active. Otherwise it is on
+// the trusted network when it is connected to a network with one of the
+// configured ESSIDs or an ESSID matching essid-pattern, through one of the
+// configured access points if bssid is set. If the essid-backend is command
+// and no ESSID is configured, the host is on the trusted network when the
+// connectivity command succeeds.
+//
+// If wired detection is configured, the host is also on the trusted network
+// when a wired interface is connected, and identified as being on the trusted
+// network if wired-gateway-mac or wired-subnet is set. The host is also on
+// the trusted network when any connected interface is on one of the trusted
+// subnets or has one of the trusted default gateways, when location-host
+// resolves to an address in one of the location subnets, or when the
+// configured VPN is up or the server or relay is online in the tailnet. If
+// wifi is not required, the host is on the trusted network when it has no
+// wireless interfaces.
+func onTrustedNetwork(c *config) (bool, error) {
+	_, err := c.essidPattern()
+	if err != nil {
+		return false, err
+	}
+	if !c.requireWifi() {
+		ok, err := hasWireless()
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return true, nil
+		}
+	}
+	if c.Wired {
+		ok, err := wiredConnected(c)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	if len(c.TrustedSubnets) != 0 || len(c.TrustedGateways) != 0 {
+		ok, err := trustedRouteConnected(c)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	if c.LocationHost != "" {
+		ok, err := locationResolved(c)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	if c.VPNInterface != "" {
+		ok, err := vpnConnected(c)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	if c.TailscalePeer != "" || c.TailscaleRelay != "" {
+		ok, err := tailscaleConnected(c)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	if c.EssidBackend == "command" && !c.hasESSIDs() {
+		return commandConnectivity(c)
+	}
+	if c.ConnectionUUID != "" {
+		backend, err := c.uuidBackend()
+		if err != nil {
+			return false, err
+		}
+		var uuids []string
+		switch backend {
+		case "nmcli":
+			uuids, err = nmcliActiveUUIDs(c)
+		case "dbus":
+			uuids, err = dbusActiveUUIDs()
+		}
+		if err != nil {
+			return false, err
+		}
+		return contains(c.ConnectionUUID, uuids), nil
+	}
+	if len(c.BSSID) != 0 {
+		return bssidConnected(c)
+	}
+	essid, err := connectedESSID(c)
+	return essid != "", err
+}
+
+// uuidBackend returns the essid-backend used to find the UUIDs of active
+// NetworkManager connections for connection-uuid. The auto essid-backend
+// uses nmcli if it is installed and otherwise busctl.
+func (c *config) uuidBackend() (string, error) {
+	backend := c.EssidBackend
+	if backend == "" {
+		backend = defaultEssidBackend
+	}
+	switch backend {
+	case "nmcli", "dbus":
+		return backend, nil
+	case "auto":
+		if _, err := exec.LookPath(c.nmcliPath()); err == nil {
+			return "nmcli", nil
+		}
+		if _, err := exec.LookPath("busctl"); err == nil {
+			return "dbus", nil
+		}
+		return "", errors.New("connection-uuid with the auto essid-backend requires nmcli or busctl")
+	default:
+		return "", fmt.Errorf("connection-uuid requires the nmcli, dbus or auto essid-backend, not %s", backend)
+	}
+}
+
+// connectedESSID returns the first of the configured ESSIDs that the host is
+// connected to, or else the first connected ESSID matching essid-pattern. It
+// returns the empty string if the host is connected to no trusted ESSID.
+func connectedESSID(c *config) (string, error) {
+	ssids, err := essids(c)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range c.ESSID {
+		if contains(e, ssids) {
+			return e, nil
+		}
+	}
+	for _, e := range ssids {
+		if c.trustedESSID(e) {
+			return e, nil
+		}
+	}
+	return "", nil
+}
+
+// deniedESSID returns the first of the ESSIDs listed in deny-essids that the
+// host is connected to, or the empty string if it is connected to none of
+// them.
+func deniedESSID(c *config) (string, error) {
+	if len(c.DenyESSIDs) == 0 {
+		return "", nil
+	}
+	ssids, err := essids(c)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range c.DenyESSIDs {
+		if contains(e, ssids) {
+			return e, nil
+		}
+	}
+	return "", nil
+}
+
+// hasESSIDs returns whether any trusted ESSIDs are configured,
+// either by name or by essid-pattern.
+func (c *config) hasESSIDs() bool {
+	return len(c.ESSID) != 0 || c.ESSIDPattern != ""
+}
+
+// essidPattern returns the compiled essid-pattern, anchored to match the
+// whole ESSID. It returns nil if no pattern is configured.
+func (c *config) essidPattern() (*regexp.Regexp, error) {
+	if c.ESSIDPattern == "" {
+		return nil, nil
+	}
+	// Check the pattern as written so that
+	// errors do not refer to the anchors.
+	_, err := regexp.Compile(c.ESSIDPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid essid-pattern: %v", err)
+	}
+	return regexp.Compile("^(?:" + c.ESSIDPattern + ")$")
+}
+
+// trustedESSID returns whether essid is one of the configured ESSIDs or
+// matches essid-pattern.
+func (c *config) trustedESSID(essid string) bool {
+	if contains(essid, c.ESSID) {
+		return true
+	}
+	re, err := c.essidPattern()
+	return err == nil && re != nil && re.MatchString(essid)
+}
+
+// trustedNetwork returns a description of the configured trusted network.
+func trustedNetwork(c *config) string {
+	var desc string
+	switch {
+	case c.ConnectionUUID != "":
+		desc = "connection " + c.ConnectionUUID
+	case c.EssidBackend == "command" && !c.hasESSIDs():
+		desc = "a trusted network according to connectivity-command"
+	default:
+		quoted := make([]string, len(c.ESSID))
+		for i, e := range c.ESSID {
+			quoted[i] = strconv.Quote(e)
+		}
+		if c.ESSIDPattern != "" {
+			quoted = append(quoted, "an ESSID matching "+strconv.Quote(c.ESSIDPattern))
+		}
+		desc = strings.Join(quoted, " or ")
+		if len(c.BSSID) != 0 {
+			desc += " through " + strings.Join(c.BSSID, " or ")
+		}
+	}
+	if c.Wired {
+		desc += " or a wired network"
+	}
+	if len(c.TrustedSubnets) != 0 || len(c.TrustedGateways) != 0 {
+		desc += " or a trusted subnet or gateway"
+	}
+	if c.LocationHost != "" {
+		desc += " or a network resolving " + c.LocationHost
+	}
+	if c.VPNInterface != "" {
+		desc += " or VPN " + c.VPNInterface
+	}
+	if c.TailscalePeer != "" || c.TailscaleRelay != "" {
+		desc += " or the tailnet"
+	}
+	return desc
+}
+
+// connection is a wireless network connection.
+type connection struct {
+	essid string
+	iface string
+	bssid string
+
+	// signal is the signal level in dBm,
+	// or zero if it is not known.
+	signal int
+}
+
+// percentToDBm returns the approximate signal level in dBm corresponding
+// to the signal quality percentage reported by NetworkManager.
+func percentToDBm(pct int) int {
+	if pct <= 0 {
+		return 0
+	}
+	return pct/2 - 100
+}
+
+// matches returns whether conn is a connection to a configured ESSID,
+// through one of the configured access points if any are configured.
+func (c *config) matches(conn connection) bool {
+	if !c.trustedESSID(conn.essid) {
+		return false
+	}
+	if len(c.BSSID) == 0 {
+		return true
+	}
+	for _, b := range c.BSSID {
+		if sameHardwareAddr(b, conn.bssid) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameHardwareAddr returns whether a and b are the same hardware address.
+func sameHardwareAddr(a, b string) bool {
+	hwa, erra := net.ParseMAC(a)
+	hwb, errb := net.ParseMAC(b)
+	if erra != nil || errb != nil {
+		return strings.EqualFold(a, b)
+	}
+	return bytes.Equal(hwa, hwb)
+}
+
+// bssidConnected returns whether the host is connected to a configured
+// ESSID through one of the configured access points.
+func bssidConnected(c *config) (bool, error) {
+	b, err := lookupEssidBackend(c.EssidBackend)
+	if err != nil {
+		return false, err
+	}
+	if b.connections == nil {
+		return false, fmt.Errorf("bssid requires an essid-backend that reports access points")
+	}
+	conns, err := b.connections(c)
+	if err != nil {
+		return false, err
+	}
+	for _, conn := range c.allowedConnections(conns) {
+		if c.matches(conn) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// allowedConnections returns the connections in conns that are on one of the
+// interfaces listed in interfaces. If no interfaces are listed, it returns
+// conns.
+func (c *config) allowedConnections(conns []connection) []connection {
+	if len(c.Interfaces) == 0 {
+		return conns
+	}
+	var allowed []connection
+	for _, conn := range conns {
+		if contains(conn.iface, c.Interfaces) {
+			allowed = append(allowed, conn)
+		}
+	}
+	return allowed
+}
+
+// connectionESSIDs returns the ESSIDs of the given connections.
+func connectionESSIDs(conns []connection) []string {
+	var essids []string
+	for _, c := range conns {
+		essids = append(essids, c.essid)
+	}
+	return essids
+}
+
+// requireWifi is the default for whether the host must be
+// connected by wifi to be on the trusted network.
+var requireWifi = true
+
+// requireWifi returns whether the host must be connected by wifi
+// to be on the trusted network when it has no wireless interfaces.
+func (c *config) requireWifi() bool {
+	if c.RequireWifi == nil {
+		return requireWifi
+	}
+	return *c.RequireWifi
+}
+
+// hasWireless returns whether the host has any wireless interfaces,
+// whether or not they are connected.
+func hasWireless() (bool, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false, err
+	}
+	for _, iface := range ifaces {
+		if _, err := os.Stat(filepath.Join("/sys/class/net", iface.Name, "wireless")); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// virtualPrefixes are the name prefixes of virtual interfaces that are
+// not considered for wired detection unless explicitly configured.
+var virtualPrefixes = []string{"docker", "veth", "br-", "virbr"}
+
+// wiredConnected returns whether a wired interface considered by the
+// configuration is connected. Wired interfaces are non-loopback interfaces
+// without wireless extensions. If wired-interfaces is set, only the listed
+// interfaces are considered, otherwise interfaces with names starting with
+// any of the virtualPrefixes are ignored.
+func wiredConnected(c *config) (bool, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false, err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if !wiredCandidate(c, iface.Name) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join("/sys/class/net", iface.Name, "wireless")); err == nil {
+			continue
+		}
+		state, err := ioutil.ReadFile(filepath.Join("/sys/class/net", iface.Name, "operstate"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(state)) != "up" {
+			continue
+		}
+		ok, err := wiredIdentified(c, iface)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// wiredIdentified returns whether the connected wired interface iface is on
+// the trusted network. If neither wired-gateway-mac nor wired-subnet is set,
+// any connected wired interface is on the trusted network.
+func wiredIdentified(c *config, iface net.Interface) (bool, error) {
+	if len(c.WiredGateway) == 0 && len(c.WiredSubnet) == 0 {
+		return true, nil
+	}
+	ok, err := onNetwork(iface, c.WiredSubnet, c.WiredGateway)
+	if err != nil {
+		return false, fmt.Errorf("wired: %v", err)
+	}
+	return ok, nil
+}
+
+// trustedRouteConnected returns whether any connected non-loopback interface
+// has an address in one of the configured trusted-subnets or an IPv4 default
+// gateway with one of the configured trusted-gateway-macs.
+func trustedRouteConnected(c *config) (bool, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false, err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		ok, err := onNetwork(iface, c.TrustedSubnets, c.TrustedGateways)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// onNetwork returns whether iface has an address in one of the given subnets,
+// in CIDR notation, or an IPv4 default gateway with one of the given hardware
+// addresses.
+func onNetwork(iface net.Interface, subnets, gateways []string) (bool, error) {
+	if len(subnets) != 0 {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return false, err
+		}
+		for _, s := range subnets {
+			_, subnet, err := net.ParseCIDR(s)
+			if err != nil {
+				return false, fmt.Errorf("invalid subnet: %v", err)
+			}
+			for _, a := range addrs {
+				ip, ok := a.(*net.IPNet)
+				if ok && subnet.Contains(ip.IP) {
+					return true, nil
+				}
+			}
+		}
+	}
+	if len(gateways) != 0 {
+		gw, err := defaultGatewayMAC(iface.Name)
+		if err != nil || gw == nil {
+			return false, err
+		}
+		for _, mac := range gateways {
+			if sameHardwareAddr(mac, gw.String()) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// defaultGatewayMAC returns the hardware address of the IPv4 default gateway
+// of the named interface from the kernel's routing and neighbour tables. It
+// returns nil if the interface has no default gateway or the gateway's
+// hardware address is not known.
+func defaultGatewayMAC(iface string) (net.HardwareAddr, error) {
+	routes, err := ioutil.ReadFile("/proc/net/route")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var gw net.IP
+	for _, l := range strings.Split(string(routes), "\n")[1:] {
+		// Iface Destination Gateway Flags ...
+		f := strings.Fields(l)
+		if len(f) < 3 || f[0] != iface || f[1] != "00000000" {
+			continue
+		}
+		v, err := strconv.ParseUint(f[2], 16, 32)
+		if err != nil || v == 0 {
+			continue
+		}
+		// Addresses are printed as host order
+		// integers holding network order bytes.
+		gw = make(net.IP, 4)
+		nativeEndian.PutUint32(gw, uint32(v))
+		break
+	}
+	if gw == nil {
+		return nil, nil
+	}
+	neigh, err := ioutil.ReadFile("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range strings.Split(string(neigh), "\n")[1:] {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		f := strings.Fields(l)
+		if len(f) < 6 || f[5] != iface || !net.ParseIP(f[0]).Equal(gw) {
+			continue
+		}
+		mac, err := net.ParseMAC(f[3])
+		if err != nil {
+			return nil, nil
+		}
+		return mac, nil
+	}
+	return nil, nil
+}
+
+// nativeEndian is the byte order of the host, which is used for
+// the integer fields of netlink messages and kernel tables.
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// wiredCandidate returns whether the named interface should be considered
+// for wired detection. Entries in wired-interfaces ending in "*" match any
+// interface with the preceding prefix.
+func wiredCandidate(c *config, name string) bool {
+	if len(c.WiredInterfaces) == 0 {
+		for _, p := range virtualPrefixes {
+			if strings.HasPrefix(name, p) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, w := range c.WiredInterfaces {
+		if w == name || strings.HasSuffix(w, "*") && strings.HasPrefix(name, strings.TrimSuffix(w, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandESSIDs returns the non-empty lines of output of the configured
+// connectivity command as the list of connected networks.
+func commandESSIDs(c *config) ([]string, error) {
+	if len(c.ConnectivityCommand) == 0 {
+		return nil, errors.New("command essid-backend requires connectivity-command")
+	}
+	return commandLines(c.ConnectivityCommand)
+}
+
+// commandConnectivity returns whether the configured connectivity command
+// exits successfully.
+func commandConnectivity(c *config) (bool, error) {
+	if len(c.ConnectivityCommand) == 0 {
+		return false, errors.New("command essid-backend requires connectivity-command")
+	}
+	err := exec.Command(c.ConnectivityCommand[0], c.ConnectivityCommand[1:]...).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// errNoNL80211 is returned when nl80211 cannot be used to determine the
+// connected wireless networks.
+var errNoNL80211 = errors.New("nl80211 not available")
+
+// nl80211ESSIDs returns the ESSIDs of wireless networks that the host is
+// connected to using nl80211.
+func nl80211ESSIDs(c *config) ([]string, error) {
+	conns, err := nl80211Connections(c)
+	return connectionESSIDs(conns), err
+}
+
+// nl80211Connections returns the wireless interfaces that the host is
+// connected to and their ESSIDs by querying the kernel over nl80211. If
+// nl80211 is not available, the output of iwconfig is used if iwconfig
+// is installed.
+func nl80211Connections(c *config) ([]connection, error) {
+	conns, err := nl80211Interfaces()
+	if !errors.Is(err, errNoNL80211) {
+		return conns, err
+	}
+	conns, iwErr := iwconfigConnections(c)
+	if errors.Is(iwErr, exec.ErrNotFound) || errors.Is(iwErr, os.ErrNotExist) {
+		return nil, err
+	}
+	return conns, iwErr
+}
+
+// wextESSIDs returns the ESSIDs of wireless networks that the host is
+// connected to using wireless extensions ioctls.
+func wextESSIDs(c *config) ([]string, error) {
+	conns, err := wextConnections(c)
+	return connectionESSIDs(conns), err
+}
+
+// wextConnections returns the wireless interfaces that the host is
+// connected to and their ESSIDs and access points using wireless
+// extensions ioctls.
+func wextConnections(c *config) ([]connection, error) {
+	return wextInterfaces()
+}
+
+// nmcliESSIDs returns the ESSIDs of wireless networks that the host is
+// connected to using nmcli.
+func nmcliESSIDs(c *config) ([]string, error) {
+	conns, err := nmcliConnections(c)
+	return connectionESSIDs(conns), err
+}
+
+// nmcliConnections returns the wireless interfaces that the host is
+// connected to and their ESSIDs, access points and signal levels using
+// nmcli.
+func nmcliConnections(c *config) ([]connection, error) {
+	lines, err := nmcli(c, "-f", "active,ssid,device,bssid,signal", "device", "wifi")
+	if err != nil {
+		return nil, err
+	}
+	var conns []connection
+	for _, l := range lines {
+		f := nmcliFields(l)
+		if len(f) == 5 && f[0] == "yes" {
+			pct, _ := strconv.Atoi(f[4])
+			conns = append(conns, connection{essid: f[1], iface: f[2], bssid: f[3], signal: percentToDBm(pct)})
+		}
+	}
+	return conns, nil
+}
+
+// nmcliActiveUUIDs returns the UUIDs of the active NetworkManager
+// connections.
+func nmcliActiveUUIDs(c *config) ([]string, error) {
+	lines, err := nmcli(c, "-f", "uuid", "connection", "show", "--active")
+	if err != nil {
+		return nil, err
+	}
+	var uuids []string
+	for _, l := range lines {
+		uuids = append(uuids, nmcliFields(l)...)
+	}
+	return uuids, nil
+}
+
+// nmcli runs nmcli in terse mode with the given arguments and returns the
+// non-empty lines of its output.
+func nmcli(c *config, args ...string) ([]string, error) {
+	return outputLines(toolCommand(c.nmcliPath(), append([]string{"-t"}, args...)...))
+}
+
+// nmcliPath returns the configured path to nmcli, or "nmcli"
+// to search $PATH if none is configured.
+func (c *config) nmcliPath() string {
+	if c.Nmcli == "" {
+		return "nmcli"
+	}
+	return c.Nmcli
+}
+
+// autoESSIDs returns the ESSIDs of wireless networks that the host is
+// connected to using the first available backend.
+func autoESSIDs(c *config) ([]string, error) {
+	conns, err := autoConnections(c)
+	return connectionESSIDs(conns), err
+}
+
+// autoConnections returns the wireless interfaces that the host is connected
+// to and their ESSIDs and access points using the first available of nl80211,
+// iwconfig, nmcli and NetworkManager over D-Bus.
+func autoConnections(c *config) ([]connection, error) {
+	conns, err := nl80211Interfaces()
+	if !errors.Is(err, errNoNL80211) {
+		return conns, err
+	}
+	path := c.Iwconfig
+	if path == "" {
+		path = iwconfig
+	}
+	if _, lerr := exec.LookPath(path); lerr == nil {
+		return iwconfigConnections(c)
+	}
+	if _, lerr := exec.LookPath(c.nmcliPath()); lerr == nil {
+		return nmcliConnections(c)
+	}
+	if _, lerr := exec.LookPath("busctl"); lerr == nil {
+		return dbusConnections(c)
+	}
+	return nil, fmt.Errorf("no essid-backend available: %v and none of iwconfig, nmcli or busctl found", err)
+}
+
+// nmcliFields splits a line of terse nmcli output into its colon-separated
+// fields, removing the escaping of colons and backslashes within fields.
+func nmcliFields(line string) []string {
+	var (
+		fields []string
+		f      strings.Builder
+	)
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if i+1 < len(line) {
+				i++
+			}
+			f.WriteByte(line[i])
+		case ':':
+			fields = append(fields, f.String())
+			f.Reset()
+		default:
+			f.WriteByte(line[i])
+		}
+	}
+	return append(fields, f.String())
+}
@@ -0,0 +1,106 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var nmcliFieldsTests = []struct {
+	line string
+	want []string
+}{
+	{line: "", want: []string{""}},
+	{line: "home", want: []string{"home"}},
+	{line: "yes:home", want: []string{"yes", "home"}},
+	{line: "yes::home", want: []string{"yes", "", "home"}},
+	{line: "yes:home:", want: []string{"yes", "home", ""}},
+	{
+		line: `yes:home:AA\:BB\:CC\:DD\:EE\:FF:72`,
+		want: []string{"yes", "home", "AA:BB:CC:DD:EE:FF", "72"},
+	},
+	{
+		line: `yes:back\\slash:AA\:BB\:CC\:DD\:EE\:FF`,
+		want: []string{"yes", `back\slash`, "AA:BB:CC:DD:EE:FF"},
+	},
+	{line: `yes:ends\\:x`, want: []string{"yes", `ends\`, "x"}},
+	{line: `yes:colon\:\\:x`, want: []string{"yes", `colon:\`, "x"}},
+	{line: `trailing\`, want: []string{`trailing\`}},
+}
+
+func TestNmcliFields(t *testing.T) {
+	for _, test := range nmcliFieldsTests {
+		got := nmcliFields(test.line)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected fields for %q: got:%q want:%q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestUUIDBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bit-user-callback")
+	if err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	tool := filepath.Join(dir, "tool")
+	err = ioutil.WriteFile(tool, []byte("#!/bin/sh\n"), 0755)
+	if err != nil {
+		t.Fatalf("unexpected error writing tool: %v", err)
+	}
+	// Only tools in dir can be found on the path.
+	setenv(t, "PATH", dir)
+	missing := filepath.Join(dir, "missing")
+
+	for _, test := range []struct {
+		backend string
+		nmcli   string
+		busctl  bool
+
+		want    string
+		wantErr bool
+	}{
+		{backend: "nmcli", nmcli: missing, want: "nmcli"},
+		{backend: "dbus", nmcli: missing, want: "dbus"},
+		{backend: "auto", nmcli: tool, want: "nmcli"},
+		{backend: "", nmcli: tool, want: "nmcli"},
+		{backend: "auto", nmcli: tool, busctl: true, want: "nmcli"},
+		{backend: "auto", nmcli: missing, busctl: true, want: "dbus"},
+		{backend: "auto", nmcli: missing, wantErr: true},
+		{backend: "iwconfig", nmcli: tool, wantErr: true},
+		{backend: "nl80211", nmcli: tool, wantErr: true},
+	} {
+		busctl := filepath.Join(dir, "busctl")
+		os.Remove(busctl)
+		if test.busctl {
+			err := os.Symlink(tool, busctl)
+			if err != nil {
+				t.Fatalf("unexpected error linking busctl: %v", err)
+			}
+		}
+		c := &config{EssidBackend: test.backend, Nmcli: test.nmcli, ConnectionUUID: "2f5a6c9e-0e1b-4d3a-9a57-3b8f3c2d1e0f"}
+		got, err := c.uuidBackend()
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for %q backend: got:%v want error:%t", test.backend, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("unexpected backend for %q backend: got:%q want:%q", test.backend, got, test.want)
+		}
+
+		var buf bytes.Buffer
+		checkConfig(c, &buf)
+		reported := strings.Contains(buf.String(), "error: connection-uuid")
+		if reported != test.wantErr {
+			t.Errorf("unexpected -check report for %q backend: got:%t want:%t\n%s", test.backend, reported, test.wantErr, &buf)
+		}
+	}
+}
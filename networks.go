@@ -0,0 +1,141 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// networkConfigs returns the configuration for each trusted network. If no
+// networks are configured, the only network is c itself. Otherwise each
+// network is a copy of c with the values in the network's configuration
+// object applied. If the object sets any of the values identifying a network,
+// none of the identifying values of c are inherited.
+func networkConfigs(c *config) ([]*config, error) {
+	if len(c.Networks) == 0 {
+		return []*config{c}, nil
+	}
+	anywhere := *c
+	anywhere.clearNetworkIdentity()
+	networks := make([]*config, len(c.Networks))
+	for i, values := range c.Networks {
+		base := c
+		for _, key := range networkIdentityKeys {
+			if _, ok := values[key]; ok {
+				base = &anywhere
+				break
+			}
+		}
+		n, err := overlay(base, values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %d: %v", i, err)
+		}
+		networks[i] = n
+	}
+	return networks, nil
+}
+
+// networkIdentityKeys are the configuration keys
+// of the values identifying a trusted network.
+var networkIdentityKeys = []string{
+	"essid",
+	"essid-pattern",
+	"bssid",
+	"connection-uuid",
+	"wired",
+	"wired-interfaces",
+	"wired-gateway-mac",
+	"wired-subnet",
+	"trusted-subnets",
+	"trusted-gateway-macs",
+	"location-host",
+	"location-subnets",
+	"vpn-interface",
+	"vpn-endpoint",
+	"vpn-allowed-ips",
+	"tailscale-peer",
+	"tailscale-relay",
+	"require-wifi",
+}
+
+// clearNetworkIdentity clears the values of c that identify a trusted network.
+func (c *config) clearNetworkIdentity() {
+	c.ESSID = nil
+	c.ESSIDPattern = ""
+	c.BSSID = nil
+	c.ConnectionUUID = ""
+	c.Wired = false
+	c.WiredInterfaces = nil
+	c.WiredGateway = nil
+	c.WiredSubnet = nil
+	c.TrustedSubnets = nil
+	c.TrustedGateways = nil
+	c.LocationHost = ""
+	c.LocationSubnets = nil
+	c.VPNInterface = ""
+	c.VPNEndpoint = nil
+	c.VPNAllowedIPs = nil
+	c.TailscalePeer = ""
+	c.TailscaleRelay = ""
+	c.RequireWifi = nil
+}
+
+// connectedNetworks returns the configurations of the trusted networks that
+// the host is connected to. If the host is connected to more than one, the
+// networks returned depend on the network-select policy: "first", the
+// default, returns only the first matching network in configuration order,
+// "all" returns all the matching networks and "error" returns an error.
+func connectedNetworks(c *config) ([]*config, error) {
+	switch c.NetworkSelect {
+	case "", "first", "all", "error":
+	default:
+		return nil, fmt.Errorf("invalid network-select: %q", c.NetworkSelect)
+	}
+	networks, err := networkConfigs(c)
+	if err != nil {
+		return nil, err
+	}
+	var connected []*config
+	for _, n := range networks {
+		ok, err := onTrustedNetwork(n)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			connected = append(connected, n)
+		}
+	}
+	if len(connected) < 2 {
+		return connected, nil
+	}
+	switch c.NetworkSelect {
+	case "all":
+		return connected, nil
+	case "error":
+		return nil, fmt.Errorf("connected to more than one configured network: %s", describeNetworks(connected, ", "))
+	default:
+		return connected[:1], nil
+	}
+}
+
+// trustedNetworks returns a description of the configured trusted networks.
+func trustedNetworks(c *config) string {
+	networks, err := networkConfigs(c)
+	if err != nil {
+		return trustedNetwork(c)
+	}
+	return describeNetworks(networks, " or ")
+}
+
+// describeNetworks returns the descriptions of the given networks
+// separated by sep.
+func describeNetworks(networks []*config, sep string) string {
+	names := make([]string, len(networks))
+	for i, n := range networks {
+		names[i] = trustedNetwork(n)
+	}
+	return strings.Join(names, sep)
+}
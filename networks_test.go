@@ -0,0 +1,110 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var connectedNetworksTests = []struct {
+	name     string
+	policy   string
+	connects string // newline-separated connected ESSIDs
+
+	want    []string
+	wantErr bool
+}{
+	{name: "default", policy: "", connects: `office\nhome`, want: []string{"http://nas.lan/"}},
+	{name: "first", policy: "first", connects: `office\nhome`, want: []string{"http://nas.lan/"}},
+	{name: "all", policy: "all", connects: `office\nhome`, want: []string{"http://nas.lan/", "http://backup.corp/"}},
+	{name: "error", policy: "error", connects: `office\nhome`, wantErr: true},
+
+	{name: "first single", policy: "first", connects: `office`, want: []string{"http://backup.corp/"}},
+	{name: "all single", policy: "all", connects: `office`, want: []string{"http://backup.corp/"}},
+	{name: "error single", policy: "error", connects: `office`, want: []string{"http://backup.corp/"}},
+
+	{name: "first none", policy: "first", connects: `cafe`, want: nil},
+	{name: "all none", policy: "all", connects: `cafe`, want: nil},
+	{name: "error none", policy: "error", connects: `cafe`, want: nil},
+
+	{name: "invalid", policy: "random", connects: `office\nhome`, wantErr: true},
+}
+
+func TestConnectedNetworks(t *testing.T) {
+	for _, test := range connectedNetworksTests {
+		var c config
+		err := json.Unmarshal([]byte(`{
+	"essid-backend": "command",
+	"connectivity-command": ["printf", "`+test.connects+`\n"],
+	"require-wifi": true,
+	"networks": [
+		{"essid": "home", "server": "http://nas.lan/"},
+		{"essid": "office", "server": "http://backup.corp/"}
+	]
+}`), &c)
+		if err != nil {
+			t.Fatalf("unexpected error unmarshaling config: %v", err)
+		}
+		c.NetworkSelect = test.policy
+
+		networks, err := connectedNetworks(&c)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for %s: got:%v want error:%t", test.name, err, test.wantErr)
+			continue
+		}
+		var got []string
+		for _, n := range networks {
+			got = append(got, n.Server)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected networks for %s: got:%q want:%q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNetworkConfigsIdentity(t *testing.T) {
+	var c config
+	err := json.Unmarshal([]byte(`{
+	"server": "http://nas.lan/",
+	"vpn-interface": "wg0",
+	"vpn-allowed-ips": "192.168.1.0/24",
+	"tailscale-peer": "nas",
+	"require-wifi": false,
+	"networks": [
+		{"essid": "home"},
+		{"server": "http://backup.corp/"}
+	]
+}`), &c)
+	if err != nil {
+		t.Fatalf("unexpected error unmarshaling config: %v", err)
+	}
+	networks, err := networkConfigs(&c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(networks) != 2 {
+		t.Fatalf("unexpected number of networks: got:%d want:2", len(networks))
+	}
+
+	home := networks[0]
+	if !reflect.DeepEqual(home.ESSID, stringList{"home"}) {
+		t.Errorf("unexpected essid: got:%q want:%q", home.ESSID, []string{"home"})
+	}
+	if home.VPNInterface != "" || home.VPNAllowedIPs != nil || home.TailscalePeer != "" || home.RequireWifi != nil {
+		t.Errorf("network setting essid inherited identity: vpn-interface:%q vpn-allowed-ips:%q tailscale-peer:%q require-wifi:%v",
+			home.VPNInterface, home.VPNAllowedIPs, home.TailscalePeer, home.RequireWifi)
+	}
+	if home.Server != "http://nas.lan/" {
+		t.Errorf("unexpected server: got:%q want:%q", home.Server, "http://nas.lan/")
+	}
+
+	backup := networks[1]
+	if backup.VPNInterface != "wg0" || backup.TailscalePeer != "nas" {
+		t.Errorf("network without identity did not inherit identity: vpn-interface:%q tailscale-peer:%q",
+			backup.VPNInterface, backup.TailscalePeer)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Generic netlink and nl80211 constants from linux/genetlink.h
+// and linux/nl80211.h.
+const (
+	genlIDCtrl         = 0x10
+	ctrlCmdGetFamily   = 3
+	ctrlAttrFamilyID   = 1
+	ctrlAttrFamilyName = 2
+
+	nl80211CmdGetIface   = 5
+	nl80211CmdGetStation = 17
+	nl80211AttrIfindex   = 3
+	nl80211AttrIfname    = 4
+	nl80211AttrMAC       = 6
+	nl80211AttrSSID      = 52
+	nl80211AttrStaInfo   = 21
+	nl80211StaInfoSignal = 7
+)
+
+// Message layout constants.
+const (
+	genlHeaderLen       = 4
+	netlinkAttrLen      = 4
+	netlinkAttrTypeMask = 0x3fff
+)
+
+// nl80211Interfaces returns the wireless interfaces that the host is
+// connected to and their ESSIDs, access points and signal levels by
+// querying the kernel over nl80211.
+// The returned error wraps errNoNL80211 if nl80211 is not available.
+func nl80211Interfaces() ([]connection, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_GENERIC)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not open netlink socket: %v", errNoNL80211, err)
+	}
+	defer syscall.Close(fd)
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not bind netlink socket: %v", errNoNL80211, err)
+	}
+
+	msgs, err := genlRequest(fd, genlIDCtrl, 0, ctrlCmdGetFamily,
+		netlinkAttr(ctrlAttrFamilyName, append([]byte("nl80211"), 0)))
+	if err == syscall.ENOENT {
+		return nil, fmt.Errorf("%w: no nl80211 family", errNoNL80211)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not resolve nl80211 family: %v", errNoNL80211, err)
+	}
+	var family uint16
+	for _, m := range msgs {
+		if a, ok := m[ctrlAttrFamilyID]; ok && len(a) >= 2 {
+			family = nativeEndian.Uint16(a)
+		}
+	}
+	if family == 0 {
+		return nil, fmt.Errorf("%w: no nl80211 family", errNoNL80211)
+	}
+
+	msgs, err = genlRequest(fd, family, syscall.NLM_F_DUMP, nl80211CmdGetIface, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not get wireless interfaces: %v", err)
+	}
+	var conns []connection
+	for _, m := range msgs {
+		ssid, ok := m[nl80211AttrSSID]
+		if !ok {
+			continue
+		}
+		name := m[nl80211AttrIfname]
+		if n := len(name); n != 0 && name[n-1] == 0 {
+			name = name[:n-1]
+		}
+		conn := connection{essid: string(ssid), iface: string(name)}
+		if index, ok := m[nl80211AttrIfindex]; ok && len(index) == 4 {
+			// The station of a connected managed
+			// interface is its access point.
+			stations, err := genlRequest(fd, family, syscall.NLM_F_DUMP, nl80211CmdGetStation,
+				netlinkAttr(nl80211AttrIfindex, index))
+			if err != nil {
+				return nil, fmt.Errorf("could not get access point of %s: %v", conn.iface, err)
+			}
+			for _, s := range stations {
+				if mac, ok := s[nl80211AttrMAC]; ok && len(mac) == 6 {
+					conn.bssid = net.HardwareAddr(mac).String()
+					info, err := parseNetlinkAttrs(s[nl80211AttrStaInfo])
+					if err != nil {
+						return nil, fmt.Errorf("could not get signal level of %s: %v", conn.iface, err)
+					}
+					if sig, ok := info[nl80211StaInfoSignal]; ok && len(sig) == 1 {
+						conn.signal = int(int8(sig[0]))
+					}
+					break
+				}
+			}
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// genlRequest sends a generic netlink request with the given command and
+// attributes to the family on the netlink socket fd, and returns the
+// attributes of each of the replies. A netlink error reply is returned as
+// a syscall.Errno.
+func genlRequest(fd int, family uint16, flags uint16, cmd uint8, attrs []byte) ([]map[uint16][]byte, error) {
+	const seq = 1
+	b := make([]byte, syscall.NLMSG_HDRLEN+genlHeaderLen, syscall.NLMSG_HDRLEN+genlHeaderLen+len(attrs))
+	b = append(b, attrs...)
+	nativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	nativeEndian.PutUint16(b[4:6], family)
+	nativeEndian.PutUint16(b[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK|flags)
+	nativeEndian.PutUint32(b[8:12], seq)
+	b[syscall.NLMSG_HDRLEN] = cmd
+	b[syscall.NLMSG_HDRLEN+1] = 1 // Version.
+	err := syscall.Sendto(fd, b, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	var replies []map[uint16][]byte
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return replies, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, fmt.Errorf("short netlink error message")
+				}
+				errno := int32(nativeEndian.Uint32(m.Data))
+				if errno != 0 {
+					return nil, syscall.Errno(-errno)
+				}
+				// An acknowledgement ends the exchange.
+				return replies, nil
+			}
+			if len(m.Data) < genlHeaderLen {
+				return nil, fmt.Errorf("short generic netlink message")
+			}
+			attrs, err := parseNetlinkAttrs(m.Data[genlHeaderLen:])
+			if err != nil {
+				return nil, err
+			}
+			replies = append(replies, attrs)
+		}
+	}
+}
+
+// netlinkAttr returns a netlink attribute with the given type and value,
+// padded to the netlink alignment.
+func netlinkAttr(typ uint16, value []byte) []byte {
+	b := make([]byte, netlinkAttrLen, nlaAlign(netlinkAttrLen+len(value)))
+	nativeEndian.PutUint16(b[0:2], uint16(netlinkAttrLen+len(value)))
+	nativeEndian.PutUint16(b[2:4], typ)
+	b = append(b, value...)
+	return b[:cap(b)]
+}
+
+// parseNetlinkAttrs returns the values of the netlink attributes in b,
+// keyed by attribute type. The padding after the last attribute may be
+// absent, but it is an error for an attribute to be truncated.
+func parseNetlinkAttrs(b []byte) (map[uint16][]byte, error) {
+	attrs := make(map[uint16][]byte)
+	for len(b) != 0 {
+		if len(b) < netlinkAttrLen {
+			return nil, fmt.Errorf("truncated netlink attribute header: %d bytes", len(b))
+		}
+		n := int(nativeEndian.Uint16(b[0:2]))
+		if n < netlinkAttrLen || n > len(b) {
+			return nil, fmt.Errorf("invalid netlink attribute length %d with %d bytes remaining", n, len(b))
+		}
+		attrs[nativeEndian.Uint16(b[2:4])&netlinkAttrTypeMask] = b[netlinkAttrLen:n]
+		n = nlaAlign(n)
+		if n > len(b) {
+			break
+		}
+		b = b[n:]
+	}
+	return attrs, nil
+}
+
+// nlaAlign returns n rounded up to the netlink attribute alignment.
+func nlaAlign(n int) int {
+	return (n + syscall.NLA_ALIGNTO - 1) &^ (syscall.NLA_ALIGNTO - 1)
+}
@@ -0,0 +1,135 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+var parseNetlinkAttrsTests = []struct {
+	name string
+	data []byte
+
+	want    map[uint16][]byte
+	wantErr bool
+}{
+	{
+		name: "empty",
+		data: []byte{},
+		want: map[uint16][]byte{},
+	},
+	{
+		name: "aligned",
+		data: []byte{
+			0x08, 0x00, 0x03, 0x00, 0x02, 0x00, 0x00, 0x00, // ifindex=2
+		},
+		want: map[uint16][]byte{3: {0x02, 0x00, 0x00, 0x00}},
+	},
+	{
+		name: "padded",
+		data: []byte{
+			0x09, 0x00, 0x34, 0x00, 'h', 'o', 'm', 'e', 0x00, 0x00, 0x00, 0x00, // ssid="home\x00" and padding
+			0x0a, 0x00, 0x06, 0x00, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x00, 0x00, // mac and padding
+		},
+		want: map[uint16][]byte{
+			52: []byte("home\x00"),
+			6:  {0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		},
+	},
+	{
+		name: "last unpadded",
+		data: []byte{
+			0x05, 0x00, 0x07, 0x00, 0xb5, // signal=-75 without padding
+		},
+		want: map[uint16][]byte{7: {0xb5}},
+	},
+	{
+		name: "nested flag masked",
+		data: []byte{
+			0x0c, 0x00, 0x15, 0x80, // sta-info with NLA_F_NESTED
+			0x05, 0x00, 0x07, 0x00, 0xb5, 0x00, 0x00, 0x00,
+		},
+		want: map[uint16][]byte{21: {0x05, 0x00, 0x07, 0x00, 0xb5, 0x00, 0x00, 0x00}},
+	},
+	{
+		name: "zero length value",
+		data: []byte{0x04, 0x00, 0x01, 0x00},
+		want: map[uint16][]byte{1: {}},
+	},
+
+	{name: "short header", data: []byte{0x08, 0x00}, wantErr: true},
+	{name: "length too short", data: []byte{0x02, 0x00, 0x03, 0x00}, wantErr: true},
+	{name: "length too long", data: []byte{0x10, 0x00, 0x03, 0x00, 0x02, 0x00, 0x00, 0x00}, wantErr: true},
+	{
+		name: "truncated second",
+		data: []byte{
+			0x08, 0x00, 0x03, 0x00, 0x02, 0x00, 0x00, 0x00,
+			0x0a, 0x00, 0x06, 0x00, 0xaa, 0xbb,
+		},
+		wantErr: true,
+	},
+	{
+		name: "trailing bytes",
+		data: []byte{
+			0x08, 0x00, 0x03, 0x00, 0x02, 0x00, 0x00, 0x00,
+			0x01,
+		},
+		wantErr: true,
+	},
+}
+
+func TestParseNetlinkAttrs(t *testing.T) {
+	if nativeEndian != binary.LittleEndian {
+		t.Skip("golden data is little endian")
+	}
+	for _, test := range parseNetlinkAttrsTests {
+		got, err := parseNetlinkAttrs(test.data)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for %s: got:%v want error:%t", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected attributes for %s:\ngot: %x\nwant:%x", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNetlinkAttrNested(t *testing.T) {
+	if nativeEndian != binary.LittleEndian {
+		t.Skip("golden data is little endian")
+	}
+	inner := netlinkAttr(nl80211StaInfoSignal, []byte{0xb5})
+	wantInner := []byte{0x05, 0x00, 0x07, 0x00, 0xb5, 0x00, 0x00, 0x00}
+	if !bytes.Equal(inner, wantInner) {
+		t.Errorf("unexpected inner attribute: got:%x want:%x", inner, wantInner)
+	}
+	outer := append(netlinkAttr(nl80211AttrStaInfo, inner), netlinkAttr(nl80211AttrMAC, []byte{1, 2, 3, 4, 5, 6})...)
+	wantOuter := []byte{
+		0x0c, 0x00, 0x15, 0x00, 0x05, 0x00, 0x07, 0x00, 0xb5, 0x00, 0x00, 0x00,
+		0x0a, 0x00, 0x06, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x00, 0x00,
+	}
+	if !bytes.Equal(outer, wantOuter) {
+		t.Errorf("unexpected outer attributes: got:%x want:%x", outer, wantOuter)
+	}
+
+	attrs, err := parseNetlinkAttrs(outer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := parseNetlinkAttrs(attrs[nl80211AttrStaInfo])
+	if err != nil {
+		t.Fatalf("unexpected error parsing nested attributes: %v", err)
+	}
+	sig := info[nl80211StaInfoSignal]
+	if len(sig) != 1 || int8(sig[0]) != -75 {
+		t.Errorf("unexpected signal: got:%x want:-75", sig)
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import "fmt"
+
+func nl80211Interfaces() ([]connection, error) {
+	return nil, fmt.Errorf("%w: not supported on this platform", errNoNL80211)
+}
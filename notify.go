@@ -0,0 +1,72 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// emailConfig is the configuration for failure notification emails.
+type emailConfig struct {
+	// Host is the SMTP server address in host:port form.
+	Host string   `json:"smtp-host"`
+	From string   `json:"from"`
+	To   []string `json:"to"`
+
+	// Username and Password are used for PLAIN
+	// authentication if Username is not empty.
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// reportFailure sends a notification of the failure if the configuration
+// requests one. Errors sending the notification are logged to fatal.
+func reportFailure(c *config, fatal *log.Logger, failure error) {
+	if c.FailureEmail == nil {
+		return
+	}
+	defer sending()()
+	err := sendFailureEmail(c.FailureEmail, failure)
+	if err != nil {
+		fatal.Printf("failed to send failure email: %v", err)
+	}
+}
+
+// sendFailureEmail sends an email describing the failure using the provided
+// email configuration.
+func sendFailureEmail(e *emailConfig, failure error) error {
+	if e.Host == "" || e.From == "" || len(e.To) == 0 {
+		return errors.New("incomplete email configuration: smtp-host, from and to are required")
+	}
+	host, _, err := net.SplitHostPort(e.Host)
+	if err != nil {
+		return fmt.Errorf("invalid smtp-host %q: %v", e.Host, err)
+	}
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown host"
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: bit-user-callback failed on %s\r\n", hostname)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "\r\n%v\r\n", failure)
+
+	return smtp.SendMail(e.Host, auth, e.From, e.To, msg.Bytes())
+}
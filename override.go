@@ -0,0 +1,182 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is the prefix for environment variables that override
+// configuration values.
+const envPrefix = "BIT_"
+
+// configFields returns the settable fields of c keyed by their configuration
+// key.
+func configFields(c *config) map[string]reflect.Value {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	fields := make(map[string]reflect.Value, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		fields[key] = v.Field(i)
+	}
+	return fields
+}
+
+// envName returns the name of the environment variable that overrides the
+// configuration value with the given key.
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// envConfigured returns whether any configuration value is set in the
+// environment.
+func envConfigured() bool {
+	for key := range configFields(&config{}) {
+		if _, ok := os.LookupEnv(envName(key)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnv overrides values in c with values set in the environment.
+func applyEnv(c *config) error {
+	for key, field := range configFields(c) {
+		name := envName(key)
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		err := setField(field, val)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// setField sets the configuration field v from text. The text is
+// interpreted as JSON if it is valid JSON for the field's type, and
+// as a JSON string otherwise. Text for a list of strings that is not
+// JSON is split at commas.
+func setField(v reflect.Value, text string) error {
+	p := reflect.New(v.Type())
+	err := json.Unmarshal([]byte(text), p.Interface())
+	if err != nil {
+		if l, ok := p.Interface().(*stringList); ok {
+			*l = splitList(text)
+			v.Set(p.Elem())
+			return nil
+		}
+		err = json.Unmarshal([]byte(strconv.Quote(text)), p.Interface())
+		if err != nil {
+			return err
+		}
+	}
+	v.Set(p.Elem())
+	return nil
+}
+
+// splitList returns the comma-separated elements of text with surrounding
+// white space removed. An empty text is an empty list.
+func splitList(text string) stringList {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	l := strings.Split(text, ",")
+	for i, e := range l {
+		l[i] = strings.TrimSpace(e)
+	}
+	return l
+}
+
+// settings is a repeatable flag holding key=value configuration overrides.
+type settings []string
+
+// String implements the flag.Value interface.
+func (s *settings) String() string {
+	return strings.Join(*s, " ")
+}
+
+// Set implements the flag.Value interface.
+func (s *settings) Set(kv string) error {
+	if !strings.Contains(kv, "=") {
+		return fmt.Errorf("invalid setting %q: must be key=value", kv)
+	}
+	*s = append(*s, kv)
+	return nil
+}
+
+// apply overrides values in c with the key=value settings in s. A key of
+// the form targets.name.key overrides the value for the target with that
+// name. It is an error for a key not to be a configuration key.
+func (s settings) apply(c *config) error {
+	fields := configFields(c)
+	for _, kv := range s {
+		i := strings.Index(kv, "=")
+		key, val := kv[:i], kv[i+1:]
+		if strings.HasPrefix(key, "targets.") {
+			err := setTarget(c, strings.TrimPrefix(key, "targets."), val)
+			if err != nil {
+				return fmt.Errorf("invalid -set %s: %v", kv, err)
+			}
+			continue
+		}
+		field, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("unknown configuration key in -set %s", kv)
+		}
+		err := setField(field, val)
+		if err != nil {
+			return fmt.Errorf("invalid value in -set %s: %v", kv, err)
+		}
+	}
+	return nil
+}
+
+// setTarget sets the configuration value for the target named in path,
+// given as name.key, to val.
+func setTarget(c *config, path, val string) error {
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		return errors.New("target setting must be targets.name.key")
+	}
+	name, key := path[:i], path[i+1:]
+	switch key {
+	case "name", "targets", "networks":
+		return fmt.Errorf("%s may not be set for a target", key)
+	}
+	var scratch config
+	field, ok := configFields(&scratch)[key]
+	if !ok {
+		return fmt.Errorf("unknown configuration key %s", key)
+	}
+	err := setField(field, val)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(field.Interface())
+	if err != nil {
+		return err
+	}
+	for _, values := range c.Targets {
+		var n string
+		if json.Unmarshal(values["name"], &n) == nil && n == name {
+			values[key] = raw
+			return nil
+		}
+	}
+	return fmt.Errorf("no target named %q", name)
+}
@@ -0,0 +1,196 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var settingsTests = []struct {
+	name string
+	set  settings
+
+	want    func(c *config) bool
+	wantErr bool
+}{
+	{
+		name: "duration string",
+		set:  settings{"wake-timeout=2m"},
+		want: func(c *config) bool { return c.Timeout == duration(2*time.Minute) },
+	},
+	{
+		name: "duration seconds",
+		set:  settings{"wake-timeout=90"},
+		want: func(c *config) bool { return c.Timeout == duration(90*time.Second) },
+	},
+	{
+		name: "list comma",
+		set:  settings{"essid=home, office"},
+		want: func(c *config) bool { return reflect.DeepEqual(c.ESSID, stringList{"home", "office"}) },
+	},
+	{
+		name: "list single",
+		set:  settings{"essid=home"},
+		want: func(c *config) bool { return reflect.DeepEqual(c.ESSID, stringList{"home"}) },
+	},
+	{
+		name: "list JSON",
+		set:  settings{`essid=["a,b","c"]`},
+		want: func(c *config) bool { return reflect.DeepEqual(c.ESSID, stringList{"a,b", "c"}) },
+	},
+	{
+		name: "list empty",
+		set:  settings{"essid="},
+		want: func(c *config) bool { return len(c.ESSID) == 0 },
+	},
+	{
+		name: "bool",
+		set:  settings{"verbose=true"},
+		want: func(c *config) bool { return c.Verbose },
+	},
+	{
+		name: "bool pointer",
+		set:  settings{"require-wifi=false"},
+		want: func(c *config) bool { return c.RequireWifi != nil && !*c.RequireWifi },
+	},
+	{
+		name: "int",
+		set:  settings{"min-signal=-75"},
+		want: func(c *config) bool { return c.MinSignal == -75 },
+	},
+	{
+		name: "float",
+		set:  settings{"wake-backoff=1.5"},
+		want: func(c *config) bool { return c.Backoff == 1.5 },
+	},
+	{
+		name: "bare string",
+		set:  settings{"server=http://other/"},
+		want: func(c *config) bool { return c.Server == "http://other/" },
+	},
+	{
+		name: "string with equals",
+		set:  settings{"server=http://other/?a=b"},
+		want: func(c *config) bool { return c.Server == "http://other/?a=b" },
+	},
+	{
+		name: "later wins",
+		set:  settings{"server=http://first/", "server=http://second/"},
+		want: func(c *config) bool { return c.Server == "http://second/" },
+	},
+	{
+		name: "target",
+		set:  settings{"targets.nas.wake-mac=01:23:45:67:89:ab", "targets.nas.wake-delay=5"},
+		want: func(c *config) bool {
+			targets, err := targetConfigs(c)
+			return err == nil &&
+				targets[0].MAC == "01:23:45:67:89:ab" && targets[0].Delay == duration(5*time.Second) &&
+				targets[1].MAC == "66:77:88:99:aa:bb"
+		},
+	},
+	{
+		name: "dotted target name",
+		set:  settings{"targets.db.lan.server=http://db.local/"},
+		want: func(c *config) bool {
+			targets, err := targetConfigs(c)
+			return err == nil && targets[1].Server == "http://db.local/"
+		},
+	},
+
+	{name: "unknown key", set: settings{"no-such-key=1"}, wantErr: true},
+	{name: "invalid int", set: settings{"min-signal=strong"}, wantErr: true},
+	{name: "invalid duration", set: settings{"wake-timeout=soon"}, wantErr: true},
+	{name: "unknown target", set: settings{"targets.backup.server=http://x/"}, wantErr: true},
+	{name: "unknown target key", set: settings{"targets.nas.no-such-key=1"}, wantErr: true},
+	{name: "target without key", set: settings{"targets.nas=1"}, wantErr: true},
+	{name: "target name", set: settings{"targets.nas.name=other"}, wantErr: true},
+}
+
+func TestSettingsApply(t *testing.T) {
+	for _, test := range settingsTests {
+		var c config
+		err := json.Unmarshal([]byte(`{
+	"server": "http://nas.lan/",
+	"targets": [
+		{"name": "nas", "server": "http://nas.lan/"},
+		{"name": "db.lan", "server": "http://db.lan/", "wake-mac": "66:77:88:99:aa:bb"}
+	]
+}`), &c)
+		if err != nil {
+			t.Fatalf("unexpected error unmarshaling config: %v", err)
+		}
+		err = test.set.apply(&c)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for %s: got:%v want error:%t", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && !test.want(&c) {
+			t.Errorf("unexpected configuration for %s: %+v", test.name, c)
+		}
+	}
+}
+
+func TestSettingsSet(t *testing.T) {
+	var s settings
+	err := s.Set("server")
+	if err == nil {
+		t.Error("expected error for setting without value")
+	}
+	err = s.Set("server=http://nas.lan/")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(s) != 1 {
+		t.Errorf("unexpected settings: %q", s)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	for k, v := range map[string]string{
+		"BIT_WAKE_TIMEOUT": "2m",
+		"BIT_ESSID":        "home,office",
+		"BIT_VERBOSE":      "true",
+	} {
+		setenv(t, k, v)
+	}
+	var c config
+	err := applyEnv(&c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Timeout != duration(2*time.Minute) {
+		t.Errorf("unexpected timeout: got:%v want:%v", time.Duration(c.Timeout), 2*time.Minute)
+	}
+	if !reflect.DeepEqual(c.ESSID, stringList{"home", "office"}) {
+		t.Errorf("unexpected essid: got:%q want:%q", c.ESSID, []string{"home", "office"})
+	}
+	if !c.Verbose {
+		t.Error("verbose not set")
+	}
+
+	setenv(t, "BIT_MIN_SIGNAL", "strong")
+	err = applyEnv(&c)
+	if err == nil {
+		t.Error("expected error for invalid environment value")
+	}
+}
+
+// setenv sets the environment variable key to val
+// for the duration of the test.
+func setenv(t *testing.T, key, val string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, val)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
@@ -0,0 +1,656 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeTimeout is the default maximum time to wait for
+// a single readiness probe to complete.
+const probeTimeout = 10 * time.Second
+
+// probeTimeout returns the maximum time to wait for a single readiness probe.
+func (c *config) probeTimeout() time.Duration {
+	if c.ProbeTimeout > 0 {
+		return time.Duration(c.ProbeTimeout)
+	}
+	return probeTimeout
+}
+
+// check is a single readiness check of the server.
+type check struct {
+	// Type is the server-check type.
+	Type string `json:"type"`
+
+	// Address is the target of the check. If it is
+	// empty, the configured server is used.
+	Address string `json:"address,omitempty"`
+
+	// Expect is the expected result of the check
+	// for check types that support it.
+	Expect string `json:"expect,omitempty"`
+
+	// Timeout is the maximum time to wait for the
+	// check to complete. If it is zero, the configured
+	// probe timeout is used.
+	Timeout duration `json:"timeout,omitempty"`
+
+	// Command is the program and arguments
+	// run by command checks.
+	Command []string `json:"command,omitempty"`
+
+	// Count is the number of echo requests sent
+	// by icmp checks.
+	Count int `json:"count,omitempty"`
+
+	// MaxLoss is the maximum percentage of echo
+	// requests that may be lost by icmp checks.
+	MaxLoss *float64 `json:"max-loss,omitempty"`
+
+	// Identity is the identity file used
+	// by ssh checks.
+	Identity string `json:"identity,omitempty"`
+
+	// Share is the share name of smb checks
+	// and the export path of nfs checks.
+	Share string `json:"share,omitempty"`
+
+	// User, Password and PasswordCommand are
+	// the credentials used by smb checks.
+	User            string   `json:"user,omitempty"`
+	Password        string   `json:"password,omitempty"`
+	PasswordCommand []string `json:"password-command,omitempty"`
+
+	// Module is the module requested by rsync checks.
+	Module string `json:"module,omitempty"`
+
+	// Service is the service checked by grpc checks.
+	// Authority is the :authority sent by grpc checks,
+	// the address by default. TLS and Fingerprint set
+	// whether grpc checks use TLS and the certificate
+	// fingerprint of the server.
+	Service     string `json:"service,omitempty"`
+	Authority   string `json:"authority,omitempty"`
+	TLS         bool   `json:"tls,omitempty"`
+	Fingerprint string `json:"cert-fingerprint,omitempty"`
+
+	// Checks are the checks combined
+	// by all and any checks.
+	Checks checks `json:"checks,omitempty"`
+}
+
+// hasParams returns whether chk has any parameters other than its type.
+func (chk check) hasParams() bool {
+	return chk.Address != "" || chk.Expect != "" || chk.Timeout != 0 || len(chk.Command) != 0 ||
+		chk.Count != 0 || chk.MaxLoss != nil || chk.Identity != "" || chk.Share != "" ||
+		chk.User != "" || chk.Password != "" || len(chk.PasswordCommand) != 0 || chk.Module != "" ||
+		chk.Service != "" || chk.Authority != "" || chk.TLS || chk.Fingerprint != "" ||
+		len(chk.Checks) != 0
+}
+
+// timeout returns the maximum time to wait for chk to complete.
+func (chk check) timeout(c *config) time.Duration {
+	if chk.Timeout > 0 {
+		return time.Duration(chk.Timeout)
+	}
+	return c.probeTimeout()
+}
+
+// target returns the address to be checked by chk.
+func (chk check) target(c *config) string {
+	if chk.Address != "" {
+		return chk.Address
+	}
+	return c.Server
+}
+
+// host returns the check's address, or the host of the configured server
+// if the check has no address.
+func (chk check) host(c *config) (string, error) {
+	if chk.Address != "" {
+		return chk.Address, nil
+	}
+	u, err := url.Parse(c.Server)
+	if err != nil {
+		return "", err
+	}
+	if u.Hostname() == "" {
+		return "", errors.New("missing address")
+	}
+	return u.Hostname(), nil
+}
+
+// checks is a list of readiness checks that must all pass for the server
+// to be considered ready. In JSON it may be given as a single check type,
+// or as an array of check types and check objects.
+type checks []check
+
+// UnmarshalJSON unmarshals a JSON check type, or array of check types
+// and objects into l. A check type may be given in the short form
+// tcp://host:port for a tcp check of that address.
+func (l *checks) UnmarshalJSON(data []byte) error {
+	var typ string
+	err := json.Unmarshal(data, &typ)
+	if err == nil {
+		*l = checks{shortCheck(typ)}
+		return nil
+	}
+	var elems []json.RawMessage
+	err = json.Unmarshal(data, &elems)
+	if err != nil {
+		return err
+	}
+	*l = make(checks, len(elems))
+	for i, e := range elems {
+		err = json.Unmarshal(e, &typ)
+		if err == nil {
+			(*l)[i] = shortCheck(typ)
+			continue
+		}
+		err = json.Unmarshal(e, &(*l)[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shortCheck returns the check described by s, either a check type or a
+// tcp://host:port address.
+func shortCheck(s string) check {
+	if strings.HasPrefix(s, "tcp://") {
+		return check{Type: "tcp", Address: strings.TrimPrefix(s, "tcp://")}
+	}
+	return check{Type: s}
+}
+
+// MarshalJSON marshals l as a check type if it holds a single check with
+// no parameters, and as an array of check objects otherwise.
+func (l checks) MarshalJSON() ([]byte, error) {
+	if len(l) == 1 && !l[0].hasParams() {
+		return json.Marshal(l[0].Type)
+	}
+	return json.Marshal([]check(l))
+}
+
+// readinessProbe returns a readiness probe that succeeds when all the
+// configured server checks succeed and, if min-uptime is set, the server
+// has been up for long enough. The error returned by the probe identifies
+// the first failing check. If verbose is not nil, each check that passes,
+// and each failing check of an any check that passes, is logged to it.
+func readinessProbe(c *config, verbose *log.Logger) (func() error, error) {
+	if c.ServerService != "" {
+		return discoveryProbe(c, verbose), nil
+	}
+	l := c.ServerCheck
+	if len(l) == 0 {
+		l = checks{{Type: defaultServerCheck}}
+	}
+	probe, err := checkProbe(c, check{Type: "all", Checks: l}, verbose)
+	if err != nil {
+		return nil, err
+	}
+	var uptime func() error
+	if c.MinUptime > 0 {
+		var err error
+		uptime, err = uptimeProbe(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return func() error {
+		err := probe()
+		if err != nil {
+			return err
+		}
+		if uptime != nil {
+			err := uptime()
+			if err != nil {
+				return fmt.Errorf("uptime check failed: %w", err)
+			}
+		}
+		return nil
+	}, nil
+}
+
+// checkProbe returns a probe for chk. If verbose is not nil, passing checks
+// other than all and any checks, and the failing checks of an any check that
+// passes, are logged to it.
+func checkProbe(c *config, chk check, verbose *log.Logger) (func() error, error) {
+	switch chk.Type {
+	case "all", "any":
+		return compositeProbe(c, chk, verbose)
+	}
+	s, err := lookupServerCheck(chk.Type)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.probe(c, chk)
+	if err != nil {
+		return nil, fmt.Errorf("%s check: %v", chk.Type, err)
+	}
+	return func() error {
+		err := p()
+		if err != nil {
+			return fmt.Errorf("%s check of %s failed: %w", chk.Type, chk.target(c), err)
+		}
+		if verbose != nil {
+			verbose.Printf("%s check of %s passed", chk.Type, chk.target(c))
+		}
+		return nil
+	}, nil
+}
+
+// compositeProbe returns a readiness probe for an all or any check. An all
+// check succeeds when each of its checks succeeds, and an any check succeeds
+// when one of its checks succeeds. The checks are made in order, and stop
+// when the outcome is known.
+func compositeProbe(c *config, chk check, verbose *log.Logger) (func() error, error) {
+	if len(chk.Checks) == 0 {
+		return nil, fmt.Errorf("%s check: missing checks", chk.Type)
+	}
+	probes := make([]func() error, len(chk.Checks))
+	for i, sub := range chk.Checks {
+		var err error
+		probes[i], err = checkProbe(c, sub, verbose)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if chk.Type == "all" {
+		return func() error {
+			for _, p := range probes {
+				err := p()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil
+	}
+	return func() error {
+		var errs anyError
+		for _, p := range probes {
+			err := p()
+			if err == nil {
+				if verbose != nil {
+					for _, err := range errs {
+						verbose.Print(err)
+					}
+				}
+				return nil
+			}
+			errs = append(errs, err)
+		}
+		return errs
+	}, nil
+}
+
+// anyError is the error returned by an any check when none of its checks
+// passed. It matches an error with errors.Is or errors.As only if the error
+// of each of its checks does, so that the failure is classified, for example
+// as the server being unreachable, only when every check failed that way.
+type anyError []error
+
+func (e anyError) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return "no check passed: " + strings.Join(s, "; ")
+}
+
+func (e anyError) Is(target error) bool {
+	for _, err := range e {
+		if !errors.Is(err, target) {
+			return false
+		}
+	}
+	return len(e) != 0
+}
+
+func (e anyError) As(target interface{}) bool {
+	if len(e) == 0 {
+		return false
+	}
+	// Check the later errors without changing
+	// target so that it is only set on a match.
+	tmp := reflect.New(reflect.TypeOf(target).Elem()).Interface()
+	for _, err := range e[1:] {
+		if !errors.As(err, tmp) {
+			return false
+		}
+	}
+	return errors.As(e[0], target)
+}
+
+// tcpProbe returns a readiness probe that succeeds when a TCP connection
+// can be made to the check's address within the check's timeout. If the
+// check has no address, the host and port of the configured server are
+// used, with the default port of the server's scheme if it has no port.
+func tcpProbe(c *config, chk check) (func() error, error) {
+	addr := chk.Address
+	if addr == "" {
+		u, err := url.Parse(c.Server)
+		if err != nil || u.Hostname() == "" {
+			return nil, errors.New("missing address")
+		}
+		port := u.Port()
+		if port == "" {
+			p, err := net.LookupPort("tcp", u.Scheme)
+			if err != nil {
+				return nil, fmt.Errorf("missing address: no port for %s", c.Server)
+			}
+			port = strconv.Itoa(p)
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid address: %v", err)
+	}
+	return func() error {
+		d := net.Dialer{Timeout: chk.timeout(c), Resolver: c.resolver()}
+		conn, err := d.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}, nil
+}
+
+// commandProbe returns a readiness probe that succeeds when the check's
+// command exits with status zero within the check's timeout and, if the
+// check has an expected value, its output contains that text. The {host}
+// placeholder in the command's arguments is replaced with the check's
+// address, or the host of the configured server.
+func commandProbe(c *config, chk check) (func() error, error) {
+	if len(chk.Command) == 0 {
+		return nil, errors.New("missing command")
+	}
+	argv := make([]string, len(chk.Command))
+	copy(argv, chk.Command)
+	for i, arg := range argv[1:] {
+		if !strings.Contains(arg, "{host}") {
+			continue
+		}
+		host, err := chk.host(c)
+		if err != nil {
+			return nil, err
+		}
+		argv[i+1] = strings.ReplaceAll(arg, "{host}", host)
+	}
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), chk.timeout(c))
+		defer cancel()
+		lines, err := outputLines(exec.CommandContext(ctx, argv[0], argv[1:]...))
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("%s: timed out after %v", argv[0], chk.timeout(c))
+			}
+			return err
+		}
+		if chk.Expect != "" && !strings.Contains(strings.Join(lines, "\n"), chk.Expect) {
+			return fmt.Errorf("%s output does not contain %q", argv[0], chk.Expect)
+		}
+		return nil
+	}, nil
+}
+
+// dnsProbe returns a readiness probe that succeeds when the check's address,
+// or the host of the configured server if no address is given, resolves. If
+// the check has an expected value, one of the resolved addresses must be that
+// IP address.
+func dnsProbe(c *config, chk check) (func() error, error) {
+	name := chk.Address
+	if name == "" {
+		u, err := url.Parse(c.Server)
+		if err != nil {
+			return nil, err
+		}
+		name = u.Hostname()
+	}
+	if name == "" {
+		return nil, errors.New("missing address")
+	}
+	var want net.IP
+	if chk.Expect != "" {
+		want = net.ParseIP(chk.Expect)
+		if want == nil {
+			return nil, fmt.Errorf("invalid expected IP address: %q", chk.Expect)
+		}
+	}
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), chk.timeout(c))
+		defer cancel()
+		addrs, err := c.resolver().LookupIPAddr(ctx, name)
+		if err != nil {
+			return err
+		}
+		if want == nil {
+			return nil
+		}
+		for _, a := range addrs {
+			if a.IP.Equal(want) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s did not resolve to %s", name, want)
+	}, nil
+}
+
+// httpProbe returns a readiness probe that succeeds when an HTTP request
+// to the check's target returns an acceptable status, 200 OK by default,
+// and the response contains the configured text. The request is made with
+// the configured method, GET by default, and body.
+func httpProbe(c *config, chk check) (func() error, error) {
+	client, err := probeClient(c)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = chk.timeout(c)
+	ready, redirects, err := readyStatus(c)
+	if err != nil {
+		return nil, err
+	}
+	if redirects {
+		// Redirect responses are acceptable, so report
+		// them rather than following them.
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	method := http.MethodGet
+	if c.Method != "" {
+		method = strings.ToUpper(c.Method)
+	}
+	contentType := c.ContentType
+	if c.Body != "" {
+		switch method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			return nil, fmt.Errorf("server-body may not be sent with %s method", method)
+		}
+		if contentType == "" {
+			contentType = "application/json"
+		}
+	}
+	url := chk.target(c)
+	return func() error {
+		var body io.Reader
+		if c.Body != "" {
+			body = strings.NewReader(c.Body)
+		}
+		req, err := http.NewRequest(method, url, body)
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if !ready(resp.StatusCode) {
+			return &statusError{status: resp.Status}
+		}
+		if c.Contains != "" {
+			body, err := responseBody(resp)
+			if err != nil {
+				return err
+			}
+			if !bytes.Contains(body, []byte(c.Contains)) {
+				return fmt.Errorf("response does not contain %q", c.Contains)
+			}
+		}
+		return nil
+	}, nil
+}
+
+// statusError is the error returned by an HTTP check when the server
+// responds with a status that is not acceptable.
+type statusError struct {
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "server returned " + e.status
+}
+
+// maxResponseBody is the maximum size of a decompressed
+// response body read by readiness probes.
+const maxResponseBody = 1 << 20
+
+// responseBody returns the body of resp, decompressing it if it is gzip
+// encoded. It is an error for the decompressed body to be larger than
+// maxResponseBody.
+func responseBody(resp *http.Response) ([]byte, error) {
+	var r io.Reader = resp.Body
+	if !resp.Uncompressed && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip response: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r, maxResponseBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseBody {
+		return nil, fmt.Errorf("response body larger than %d bytes", maxResponseBody)
+	}
+	return body, nil
+}
+
+// readyStatus returns a function reporting whether an HTTP status code
+// indicates that the server is ready according to the configured
+// server-status codes and server-status-class classes. A code is accepted
+// if it is listed in either. If neither is configured, only 200 OK is
+// accepted. The returned redirects value is true if any 3xx code is
+// accepted.
+func readyStatus(c *config) (ready func(int) bool, redirects bool, err error) {
+	if len(c.Status) == 0 && len(c.StatusClass) == 0 {
+		return func(code int) bool { return code == http.StatusOK }, false, nil
+	}
+	codes := make(map[int]bool)
+	for _, code := range c.Status {
+		if code < 100 || 599 < code {
+			return nil, false, fmt.Errorf("invalid server-status code: %d", code)
+		}
+		codes[code] = true
+		redirects = redirects || code/100 == 3
+	}
+	var classes [6]bool
+	for _, class := range c.StatusClass {
+		switch class := strings.ToLower(class); class {
+		case "any":
+			for i := 1; i < len(classes); i++ {
+				classes[i] = true
+			}
+		case "1xx", "2xx", "3xx", "4xx", "5xx":
+			classes[class[0]-'0'] = true
+		default:
+			return nil, false, fmt.Errorf("invalid server-status-class: %q", class)
+		}
+	}
+	redirects = redirects || classes[3]
+	return func(code int) bool {
+		return codes[code] || (0 < code/100 && code/100 < len(classes) && classes[code/100])
+	}, redirects, nil
+}
+
+// probeClient returns an HTTP client for readiness probes of the configured
+// server, resolving names with the configured resolver. If a server
+// certificate fingerprint is configured, the client only accepts TLS
+// connections to a server presenting the certificate with that SHA-256
+// fingerprint, and does not otherwise verify the certificate chain.
+func probeClient(c *config) (*http.Client, error) {
+	if c.Fingerprint == "" && c.DNSServer == "" {
+		return &http.Client{Timeout: c.probeTimeout()}, nil
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if c.DNSServer != "" {
+		d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Resolver: c.resolver()}
+		t.DialContext = d.DialContext
+	}
+	if c.Fingerprint == "" {
+		return &http.Client{Transport: t, Timeout: c.probeTimeout()}, nil
+	}
+	tlsConfig, err := pinnedTLSConfig("server", c.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: t, Timeout: c.probeTimeout()}, nil
+}
+
+// pinnedTLSConfig returns a TLS configuration that only accepts a peer
+// presenting the certificate with the given hex-encoded SHA-256 fingerprint.
+// The name is used to identify the peer in errors.
+func pinnedTLSConfig(name, fingerprint string) (*tls.Config, error) {
+	want, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+	if err != nil || len(want) != sha256.Size {
+		return nil, fmt.Errorf("invalid %s certificate fingerprint %q: must be a hex-encoded SHA-256 sum", name, fingerprint)
+	}
+	return &tls.Config{
+		// The certificate chain and host name are not verified
+		// by the standard mechanism, the pinned fingerprint is
+		// checked by VerifyConnection instead.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("%s presented no certificate", name)
+			}
+			got := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if !bytes.Equal(got[:], want) {
+				return fmt.Errorf("%s certificate fingerprint mismatch: got %x", name, got)
+			}
+			return nil
+		},
+	}, nil
+}
@@ -0,0 +1,343 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var checksTests = []struct {
+	data string
+
+	want     checks
+	wantJSON string
+}{
+	{
+		data:     `"http"`,
+		want:     checks{{Type: "http"}},
+		wantJSON: `"http"`,
+	},
+	{
+		data:     `"tcp://nas.lan:22"`,
+		want:     checks{{Type: "tcp", Address: "nas.lan:22"}},
+		wantJSON: `[{"type":"tcp","address":"nas.lan:22"}]`,
+	},
+	{
+		data:     `["http"]`,
+		want:     checks{{Type: "http"}},
+		wantJSON: `"http"`,
+	},
+	{
+		data:     `["http", "tcp://nas.lan:22"]`,
+		want:     checks{{Type: "http"}, {Type: "tcp", Address: "nas.lan:22"}},
+		wantJSON: `[{"type":"http"},{"type":"tcp","address":"nas.lan:22"}]`,
+	},
+	{
+		data: `[{"type": "tcp", "address": "nas.lan:2049", "timeout": "2s"}, "http"]`,
+		want: checks{
+			{Type: "tcp", Address: "nas.lan:2049", Timeout: duration(2 * time.Second)},
+			{Type: "http"},
+		},
+		wantJSON: `[{"type":"tcp","address":"nas.lan:2049","timeout":"2s"},{"type":"http"}]`,
+	},
+	{
+		data: `["icmp", {"type": "any", "checks": ["tcp://nas.lan:22", {"type": "ssh"}]}]`,
+		want: checks{
+			{Type: "icmp"},
+			{Type: "any", Checks: checks{{Type: "tcp", Address: "nas.lan:22"}, {Type: "ssh"}}},
+		},
+		wantJSON: `[{"type":"icmp"},{"type":"any","checks":[{"type":"tcp","address":"nas.lan:22"},{"type":"ssh"}]}]`,
+	},
+}
+
+func TestChecksJSON(t *testing.T) {
+	for _, test := range checksTests {
+		var got checks
+		err := json.Unmarshal([]byte(test.data), &got)
+		if err != nil {
+			t.Errorf("unexpected error unmarshaling %s: %v", test.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected checks for %s:\ngot: %+v\nwant:%+v", test.data, got, test.want)
+		}
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Errorf("unexpected error marshaling %s: %v", test.data, err)
+			continue
+		}
+		if string(b) != test.wantJSON {
+			t.Errorf("unexpected JSON for %s: got:%s want:%s", test.data, b, test.wantJSON)
+		}
+		var round checks
+		err = json.Unmarshal(b, &round)
+		if err != nil {
+			t.Errorf("unexpected error unmarshaling %s: %v", b, err)
+			continue
+		}
+		if !reflect.DeepEqual(round, test.want) {
+			t.Errorf("checks for %s not preserved by round trip:\ngot: %+v\nwant:%+v", test.data, round, test.want)
+		}
+	}
+}
+
+func TestChecksJSONInvalid(t *testing.T) {
+	for _, data := range []string{`1`, `{"type": "http"}`, `[1]`, `["http", {"type": 1}]`} {
+		var got checks
+		err := json.Unmarshal([]byte(data), &got)
+		if err == nil {
+			t.Errorf("expected error unmarshaling %s", data)
+		}
+	}
+}
+
+func TestHTTPProbeBody(t *testing.T) {
+	var (
+		gotMethod      string
+		gotContentType string
+		gotBody        string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotContentType = r.Header.Get("Content-Type")
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		gotBody = string(b)
+		if !strings.Contains(gotBody, `"probe":"ready"`) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	for _, test := range []struct {
+		method      string
+		body        string
+		contentType string
+
+		wantMethod      string
+		wantContentType string
+		wantReady       bool
+	}{
+		{
+			method: "post", body: `{"probe":"ready"}`,
+			wantMethod: http.MethodPost, wantContentType: "application/json", wantReady: true,
+		},
+		{
+			method: "PUT", body: `{"probe":"ready"}`, contentType: "text/plain",
+			wantMethod: http.MethodPut, wantContentType: "text/plain", wantReady: true,
+		},
+		{
+			method: "POST", body: `{"probe":"status"}`,
+			wantMethod: http.MethodPost, wantContentType: "application/json", wantReady: false,
+		},
+		{
+			wantMethod: http.MethodGet, wantReady: false,
+		},
+	} {
+		gotMethod, gotContentType, gotBody = "", "", ""
+		c := &config{Server: srv.URL, Method: test.method, Body: test.body, ContentType: test.contentType}
+		probe, err := httpProbe(c, check{Type: "http"})
+		if err != nil {
+			t.Errorf("unexpected error constructing probe for %s: %v", test.method, err)
+			continue
+		}
+		err = probe()
+		if (err == nil) != test.wantReady {
+			t.Errorf("unexpected readiness for %s %s: got:%v want ready:%t", test.method, test.body, err, test.wantReady)
+		}
+		if gotMethod != test.wantMethod {
+			t.Errorf("unexpected method: got:%q want:%q", gotMethod, test.wantMethod)
+		}
+		if gotContentType != test.wantContentType {
+			t.Errorf("unexpected content type: got:%q want:%q", gotContentType, test.wantContentType)
+		}
+		if gotBody != test.body {
+			t.Errorf("unexpected body: got:%q want:%q", gotBody, test.body)
+		}
+	}
+}
+
+func TestHTTPProbeBodyMethod(t *testing.T) {
+	for _, method := range []string{"", "GET", "head", "DELETE"} {
+		c := &config{Server: "http://localhost/", Method: method, Body: `{"probe":"ready"}`}
+		_, err := httpProbe(c, check{Type: "http"})
+		if err == nil {
+			t.Errorf("expected error for body with %q method", method)
+		}
+	}
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	if err != nil {
+		t.Fatalf("unexpected error compressing data: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error compressing data: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestHTTPProbeGzip(t *testing.T) {
+	page := gzipped(t, []byte("<html><body>status: all services ready</body></html>"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Send the page compressed whether or
+		// not the client asked for it.
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(page)
+	}))
+	defer srv.Close()
+
+	for _, test := range []struct {
+		contains  string
+		wantReady bool
+	}{
+		{contains: "all services ready", wantReady: true},
+		{contains: "maintenance", wantReady: false},
+	} {
+		c := &config{Server: srv.URL, Contains: test.contains}
+		probe, err := httpProbe(c, check{Type: "http"})
+		if err != nil {
+			t.Fatalf("unexpected error constructing probe: %v", err)
+		}
+		err = probe()
+		if (err == nil) != test.wantReady {
+			t.Errorf("unexpected readiness for %q: got:%v want ready:%t", test.contains, err, test.wantReady)
+		}
+	}
+}
+
+func TestResponseBody(t *testing.T) {
+	const page = "status: ready"
+	for _, test := range []struct {
+		name     string
+		encoding string
+		body     []byte
+
+		want    string
+		wantErr bool
+	}{
+		{name: "identity", body: []byte(page), want: page},
+		{name: "gzip", encoding: "gzip", body: gzipped(t, []byte(page)), want: page},
+		{name: "gzip case", encoding: "GZIP", body: gzipped(t, []byte(page)), want: page},
+		{name: "invalid gzip", encoding: "gzip", body: []byte(page), wantErr: true},
+		{name: "bomb", encoding: "gzip", body: gzipped(t, make([]byte, maxResponseBody+1)), wantErr: true},
+	} {
+		resp := &http.Response{
+			Header: http.Header{},
+			Body:   ioutil.NopCloser(bytes.NewReader(test.body)),
+		}
+		if test.encoding != "" {
+			resp.Header.Set("Content-Encoding", test.encoding)
+		}
+		got, err := responseBody(resp)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for %s: got:%v want error:%t", test.name, err, test.wantErr)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("unexpected body for %s: got:%q want:%q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAnyErrorClass(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	netUnreach := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ENETUNREACH}
+	unavailable := &statusError{status: "503 Service Unavailable"}
+	for _, test := range []struct {
+		name string
+		err  anyError
+
+		wantNetworkDown bool
+		wantUnreachable bool
+		wantNearReady   bool
+	}{
+		{name: "all refused", err: anyError{refused, refused}, wantUnreachable: true},
+		{name: "all network down", err: anyError{netUnreach, netUnreach}, wantNetworkDown: true, wantUnreachable: true},
+		{name: "some network down", err: anyError{refused, netUnreach}, wantUnreachable: true},
+		{name: "all unavailable", err: anyError{unavailable, unavailable}, wantNearReady: true},
+		{name: "refused and unavailable", err: anyError{refused, unavailable}},
+		{name: "unavailable and refused", err: anyError{unavailable, refused}},
+		{name: "nested", err: anyError{refused, anyError{refused, refused}}, wantUnreachable: true},
+	} {
+		if got := networkDown(test.err); got != test.wantNetworkDown {
+			t.Errorf("unexpected networkDown for %s: got:%t want:%t", test.name, got, test.wantNetworkDown)
+		}
+		if got := unreachable(test.err); got != test.wantUnreachable {
+			t.Errorf("unexpected unreachable for %s: got:%t want:%t", test.name, got, test.wantUnreachable)
+		}
+		if got := nearReady(test.err); got != test.wantNearReady {
+			t.Errorf("unexpected nearReady for %s: got:%t want:%t", test.name, got, test.wantNearReady)
+		}
+	}
+}
+
+func TestAnyProbeVerbose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	open := l.Addr().String()
+	l.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	verbose := log.New(&buf, "", 0)
+	c := &config{Server: srv.URL}
+	chk := check{Type: "any", Checks: checks{
+		{Type: "tcp", Address: open},
+		{Type: "http"},
+	}}
+	probe, err := checkProbe(c, chk, verbose)
+	if err != nil {
+		t.Fatalf("unexpected error constructing probe: %v", err)
+	}
+	err = probe()
+	if err != nil {
+		t.Fatalf("unexpected probe failure: %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"tcp check of " + open + " failed",
+		"http check of " + srv.URL + " passed",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("verbose log missing %q:\n%s", want, got)
+		}
+	}
+
+	chk.Checks = checks{{Type: "tcp", Address: open}, {Type: "tcp", Address: open}}
+	probe, err = checkProbe(c, chk, nil)
+	if err != nil {
+		t.Fatalf("unexpected error constructing probe: %v", err)
+	}
+	err = probe()
+	if !unreachable(err) {
+		t.Errorf("expected unreachable error when all checks are refused: got:%v", err)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// sendEtherFrame sends payload in an ethernet frame with the given
+// EtherType to the hardware address dst from the interface iface.
+// It requires CAP_NET_RAW.
+func sendEtherFrame(iface *net.Interface, dst net.HardwareAddr, etherType uint16, payload []byte) error {
+	proto := htons(etherType)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(fd)
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, dst...)
+	frame = append(frame, iface.HardwareAddr...)
+	frame = append(frame, byte(etherType>>8), byte(etherType))
+	frame = append(frame, payload...)
+
+	addr := syscall.SockaddrLinklayer{
+		Protocol: proto,
+		Ifindex:  iface.Index,
+		Halen:    uint8(len(dst)),
+	}
+	copy(addr.Addr[:], dst)
+	return os.NewSyscallError("sendto", syscall.Sendto(fd, frame, 0, &addr))
+}
+
+// htons returns v in network byte order.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	nativeEndian.PutUint16(b[:], v)
+	return uint16(b[0])<<8 | uint16(b[1])
+}
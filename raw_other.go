@@ -0,0 +1,17 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func sendEtherFrame(iface *net.Interface, dst net.HardwareAddr, etherType uint16, payload []byte) error {
+	return errors.New("raw ethernet frames not supported on this platform")
+}
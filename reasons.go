@@ -0,0 +1,35 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// reasons are the reasons a Back In Time user callback is invoked, as
+// described at https://github.com/bit-team/user-callback.
+var reasons = []struct {
+	code, desc string
+}{
+	{"1", "backup process begins"},
+	{"2", "backup process ends"},
+	{"3", "a new snapshot was taken"},
+	{"4", "there was an error"},
+	{"5", "graphical application starts"},
+	{"6", "graphical application closes"},
+	{mount, "mount all necessary drives"},
+	{"8", "unmount all drives"},
+}
+
+// printReasons writes the known Back In Time callback reasons to w.
+func printReasons(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, r := range reasons {
+		fmt.Fprintf(tw, "%s\t%s\n", r.code, r.desc)
+	}
+	tw.Flush()
+}
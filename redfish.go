@@ -0,0 +1,131 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// redfishResource is the subset of a Redfish resource used to find and
+// power on a computer system.
+type redfishResource struct {
+	Members []struct {
+		ID string `json:"@odata.id"`
+	} `json:"Members"`
+	PowerState string `json:"PowerState"`
+	Actions    struct {
+		Reset struct {
+			Target string `json:"target"`
+		} `json:"#ComputerSystem.Reset"`
+	} `json:"Actions"`
+}
+
+// wakeRedfish powers on the server by requesting a ComputerSystem.Reset
+// action with ResetType On from the BMC at the configured redfish-url.
+// If redfish-system is not set, the BMC must manage a single system.
+func wakeRedfish(c *config) error {
+	if c.RedfishURL == "" {
+		return errors.New("redfish wake-mode requires redfish-url")
+	}
+	password, err := secret(c.RedfishPassword, c.RedfishPasswordCommand)
+	if err != nil {
+		return fmt.Errorf("could not get redfish password: %v", err)
+	}
+	client, err := tlsClient(c, "redfish", c.RedfishFingerprint, c.RedfishInsecure)
+	if err != nil {
+		return err
+	}
+	do := func(method, path string, body, dst interface{}) error {
+		var r io.Reader
+		if body != nil {
+			b, err := json.Marshal(body)
+			if err != nil {
+				return err
+			}
+			r = bytes.NewReader(b)
+		}
+		req, err := http.NewRequest(method, strings.TrimSuffix(c.RedfishURL, "/")+path, r)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.RedfishUser != "" {
+			req.SetBasicAuth(c.RedfishUser, password)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			return fmt.Errorf("redfish %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+		}
+		if dst == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(dst)
+	}
+
+	system := "/redfish/v1/Systems/" + c.RedfishSystem
+	if c.RedfishSystem == "" {
+		var systems redfishResource
+		err = do(http.MethodGet, "/redfish/v1/Systems", nil, &systems)
+		if err != nil {
+			return err
+		}
+		if len(systems.Members) != 1 {
+			return fmt.Errorf("redfish: found %d systems, set redfish-system to select one", len(systems.Members))
+		}
+		system = systems.Members[0].ID
+	}
+	var sys redfishResource
+	err = do(http.MethodGet, system, nil, &sys)
+	if err != nil {
+		return err
+	}
+	if sys.PowerState == "On" {
+		// Some BMCs reject a reset to On for a system
+		// that is already on.
+		return nil
+	}
+	target := sys.Actions.Reset.Target
+	if target == "" {
+		target = system + "/Actions/ComputerSystem.Reset"
+	}
+	return do(http.MethodPost, target, map[string]string{"ResetType": "On"}, nil)
+}
+
+// tlsClient returns an HTTP client for requests to the named management
+// service. If fingerprint is not empty, only a service presenting the
+// certificate with that fingerprint is accepted. Otherwise, if insecure is
+// true, the service's certificate is not verified.
+func tlsClient(c *config, name, fingerprint string, insecure bool) (*http.Client, error) {
+	if fingerprint == "" && !insecure {
+		return &http.Client{Timeout: c.probeTimeout()}, nil
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if fingerprint != "" {
+		tlsConfig, err := pinnedTLSConfig(name, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = tlsConfig
+	} else {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{Transport: t, Timeout: c.probeTimeout()}, nil
+}
@@ -0,0 +1,73 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net"
+)
+
+// resolver returns the resolver used for the server and wake addresses.
+// If dns-server is set, names are resolved by querying that server,
+// otherwise the system resolver is used.
+func (c *config) resolver() *net.Resolver {
+	if c.DNSServer == "" {
+		return net.DefaultResolver
+	}
+	addr := c.DNSServer
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// lookupIP returns the IP addresses of host using the configured resolver.
+func lookupIP(c *config, host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.probeTimeout())
+	defer cancel()
+	addrs, err := c.resolver().LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, nil
+}
+
+// resolveUDPAddr returns the address of the UDP end point addr in network,
+// which must be "udp", "udp4" or "udp6", using the configured resolver.
+func resolveUDPAddr(c *config, network, addr string) (*net.UDPAddr, error) {
+	if c.DNSServer == "" {
+		return net.ResolveUDPAddr(network, addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || net.ParseIP(host) != nil {
+		// No name to resolve.
+		return net.ResolveUDPAddr(network, addr)
+	}
+	p, err := net.LookupPort(network, port)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := lookupIP(c, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		is4 := ip.To4() != nil
+		if network == "udp" || is4 && network == "udp4" || !is4 && network == "udp6" {
+			return &net.UDPAddr{IP: ip, Port: p}, nil
+		}
+	}
+	return nil, &net.AddrError{Err: "no suitable address found", Addr: addr}
+}
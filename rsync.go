@@ -0,0 +1,86 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// rsyncPort is the default rsync daemon port.
+	rsyncPort = "873"
+
+	// rsyncProtocol is the rsync daemon protocol version
+	// sent in the greeting by rsync checks.
+	rsyncProtocol = "31.0"
+)
+
+// rsyncProbe returns a readiness probe that succeeds when the rsync daemon
+// at the check's address, or the host of the configured server, completes
+// the @RSYNCD: greeting and accepts a request for the check's module. A
+// module that requires authentication is considered available. If the check
+// has no module, the daemon must only complete the greeting.
+func rsyncProbe(c *config, chk check) (func() error, error) {
+	addr, err := chk.host(c)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, rsyncPort)
+	}
+	if strings.ContainsAny(chk.Module, "\n/") {
+		return nil, fmt.Errorf("invalid module: %q", chk.Module)
+	}
+	return func() error {
+		d := net.Dialer{Timeout: chk.timeout(c), Resolver: c.resolver()}
+		conn, err := d.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(chk.timeout(c)))
+		r := bufio.NewReader(conn)
+
+		greeting, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("no rsync greeting: %v", err)
+		}
+		if !strings.HasPrefix(greeting, "@RSYNCD: ") {
+			return fmt.Errorf("unexpected rsync greeting: %q", strings.TrimSpace(greeting))
+		}
+		_, err = fmt.Fprintf(conn, "@RSYNCD: %s\n", rsyncProtocol)
+		if err != nil {
+			return err
+		}
+		if chk.Module == "" {
+			return nil
+		}
+		_, err = fmt.Fprintf(conn, "%s\n", chk.Module)
+		if err != nil {
+			return err
+		}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return fmt.Errorf("no rsync module response: %v", err)
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "@RSYNCD: OK", strings.HasPrefix(line, "@RSYNCD: AUTHREQD"):
+				return nil
+			case strings.HasPrefix(line, "@ERROR"):
+				return errors.New(strings.TrimSpace(strings.TrimPrefix(line, "@ERROR:")))
+			case line == "@RSYNCD: EXIT":
+				return fmt.Errorf("rsync daemon closed the connection for module %s", chk.Module)
+			}
+			// Other lines are the daemon's message of the day.
+		}
+	}, nil
+}
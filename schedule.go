@@ -0,0 +1,131 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// pollSchedule determines the delays between readiness probes.
+type pollSchedule struct {
+	c *config
+
+	// delay is the current delay before
+	// adjustment for expected boot time.
+	delay time.Duration
+}
+
+// newPollSchedule returns a new pollSchedule for the given configuration.
+func newPollSchedule(c *config) *pollSchedule {
+	return &pollSchedule{c: c, delay: time.Duration(c.Delay)}
+}
+
+// next returns the time to wait before the next readiness probe, given the
+// time since the wake was sent and the error returned by the last probe.
+//
+// If a backoff factor greater than one is configured, each delay is longer
+// than the previous by that factor, up to the configured maximum delay. If
+// the last probe failed because the local network was down, the delay is
+// reset to the configured delay so that probing resumes promptly when the
+// network returns.
+//
+// If an expected boot time is configured, probes are made at the current
+// delay until three quarters of the expected boot time has elapsed, and then
+// at a quarter of the configured delay so that readiness is detected promptly.
+// The delay before the faster cadence starts is shortened so that the first
+// fast probe is not later than the start of the faster cadence.
+//
+// If a near-ready delay is configured and the last probe reached the server
+// but found it not ready, the near-ready delay is returned and the current
+// delay is left unchanged.
+func (s *pollSchedule) next(sinceWake time.Duration, err error) time.Duration {
+	if s.c.NearReadyDelay > 0 && nearReady(err) {
+		return time.Duration(s.c.NearReadyDelay)
+	}
+	if networkDown(err) {
+		s.delay = time.Duration(s.c.Delay)
+	}
+	delay := s.delay
+	if s.c.Backoff > 1 {
+		s.delay = time.Duration(float64(s.delay) * s.c.Backoff)
+		if limit := time.Duration(s.c.MaxDelay); limit > 0 && s.delay > limit {
+			s.delay = limit
+		}
+	}
+
+	boot := time.Duration(s.c.ExpectedBootTime)
+	if boot <= 0 {
+		return delay
+	}
+	fast := boot - boot/4
+	switch {
+	case sinceWake >= fast:
+		return time.Duration(s.c.Delay) / 4
+	case sinceWake+delay > fast:
+		return fast - sinceWake
+	default:
+		return delay
+	}
+}
+
+// printSchedule writes the times at which readiness probes would be made
+// for the configuration in c, assuming that the server never becomes ready
+// and the network remains up. It returns an error if the schedule would not
+// advance.
+func printSchedule(c *config, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "probe\ttime\tdelay\t")
+	s := newPollSchedule(c)
+	var elapsed time.Duration
+	for n := 1; ; n++ {
+		if elapsed > time.Duration(c.Timeout) {
+			fmt.Fprintf(tw, "timeout\t%v\t\t\n", elapsed.Round(time.Millisecond))
+			break
+		}
+		d := s.next(elapsed, nil)
+		fmt.Fprintf(tw, "%d\t%v\t%v\t\n", n, elapsed.Round(time.Millisecond), d.Round(time.Millisecond))
+		if d <= 0 {
+			tw.Flush()
+			return fmt.Errorf("probe delay %v does not advance: wake-delay must be positive", d)
+		}
+		elapsed += d
+	}
+	return tw.Flush()
+}
+
+// networkDown returns whether err indicates that the local network is not
+// available, rather than that the server could not be reached or was not
+// ready.
+func networkDown(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.ENETDOWN) || errors.Is(err, syscall.EADDRNOTAVAIL) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && (dnsErr.IsTimeout || dnsErr.IsTemporary)
+}
+
+// unreachable returns whether err indicates that a connection to the server
+// could not be made, rather than that the server responded but was not ready.
+func unreachable(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// nearReady returns whether err indicates that the server was reached but
+// was not yet ready, because an HTTP check received a response with a status
+// that is not acceptable.
+func nearReady(err error) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr)
+}
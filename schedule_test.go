@@ -0,0 +1,68 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+)
+
+var errorClassTests = []struct {
+	name string
+	err  error
+
+	wantNetworkDown bool
+	wantUnreachable bool
+	wantNearReady   bool
+}{
+	{name: "nil"},
+	{
+		name:          "status",
+		err:           &statusError{status: "503 Service Unavailable"},
+		wantNearReady: true,
+	},
+	{
+		name:          "wrapped status",
+		err:           fmt.Errorf("http check: %w", &statusError{status: "503 Service Unavailable"}),
+		wantNearReady: true,
+	},
+	{
+		name:            "refused",
+		err:             &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+		wantUnreachable: true,
+	},
+	{
+		name:            "network unreachable",
+		err:             fmt.Errorf("tcp check: %w", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ENETUNREACH}),
+		wantNetworkDown: true,
+		wantUnreachable: true,
+	},
+	{
+		name:            "dns timeout",
+		err:             &net.DNSError{Err: "timeout", Name: "nas.lan", IsTimeout: true},
+		wantNetworkDown: true,
+	},
+	{name: "command", err: errors.New("command check: exit status 1")},
+	{name: "contains", err: errors.New(`response does not contain "ready"`)},
+	{name: "fingerprint", err: errors.New("server certificate fingerprint mismatch")},
+	{name: "dns not found", err: &net.DNSError{Err: "no such host", Name: "nas.lan", IsNotFound: true}},
+}
+
+func TestErrorClass(t *testing.T) {
+	for _, test := range errorClassTests {
+		if got := networkDown(test.err); got != test.wantNetworkDown {
+			t.Errorf("unexpected networkDown for %s: got:%t want:%t", test.name, got, test.wantNetworkDown)
+		}
+		if got := unreachable(test.err); got != test.wantUnreachable {
+			t.Errorf("unexpected unreachable for %s: got:%t want:%t", test.name, got, test.wantUnreachable)
+		}
+		if got := nearReady(test.err); got != test.wantNearReady {
+			t.Errorf("unexpected nearReady for %s: got:%t want:%t", test.name, got, test.wantNearReady)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// smbProbe returns a readiness probe that succeeds when smbclient can
+// connect to the check's share on the check's address, or the host of the
+// configured server. The connection is made as the check's user with the
+// password given by the check, or anonymously if no user is given.
+func smbProbe(c *config, chk check) (func() error, error) {
+	host, err := chk.host(c)
+	if err != nil {
+		return nil, err
+	}
+	if chk.Share == "" {
+		return nil, errors.New("missing share")
+	}
+	service := "//" + host + "/" + strings.TrimPrefix(chk.Share, "/")
+	return func() error {
+		password, err := secret(chk.Password, chk.PasswordCommand)
+		if err != nil {
+			return fmt.Errorf("could not get smb password: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), chk.timeout(c))
+		defer cancel()
+		args := []string{service, "-c", "exit"}
+		if chk.User == "" {
+			args = append(args, "-N")
+		} else {
+			args = append(args, "-U", chk.User)
+		}
+		cmd := exec.CommandContext(ctx, "smbclient", args...)
+		cmd.Env = toolCommand("smbclient").Env
+		if chk.User != "" {
+			// The password is passed in the environment
+			// so that it is not visible in the process list.
+			cmd.Env = append(cmd.Env, "PASSWD="+password)
+		}
+		_, err = outputLines(cmd)
+		return err
+	}, nil
+}
+
+// nfsProbe returns a readiness probe that succeeds when the check's address,
+// or the host of the configured server, exports the check's share over NFS,
+// or exports anything if the check has no share.
+func nfsProbe(c *config, chk check) (func() error, error) {
+	host, err := chk.host(c)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), chk.timeout(c))
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "showmount", "--exports", "--no-headers", host)
+		cmd.Env = toolCommand("showmount").Env
+		lines, err := outputLines(cmd)
+		if err != nil {
+			return err
+		}
+		if chk.Share == "" {
+			if len(lines) == 0 {
+				return fmt.Errorf("%s has no exports", host)
+			}
+			return nil
+		}
+		for _, l := range lines {
+			f := strings.Fields(l)
+			if len(f) != 0 && f[0] == chk.Share {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s does not export %s", host, chk.Share)
+	}, nil
+}
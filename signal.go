@@ -0,0 +1,54 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultWeakSignalExit is the default exit status used when no
+// wake is sent because the wireless signal is too weak.
+const defaultWeakSignalExit = 4
+
+// weakSignal returns a description of the connections to the trusted
+// networks in networks whose signal level is below the network's
+// configured min-signal. Connections with an unknown signal level and
+// networks reached without a wireless connection are not considered weak.
+func weakSignal(networks []*config) (string, error) {
+	var weak []string
+	for _, n := range networks {
+		if n.MinSignal == 0 || !n.hasESSIDs() {
+			continue
+		}
+		b, err := lookupEssidBackend(n.EssidBackend)
+		if err != nil {
+			return "", err
+		}
+		if b.connections == nil {
+			continue
+		}
+		conns, err := b.connections(n)
+		if err != nil {
+			return "", err
+		}
+		for _, conn := range n.allowedConnections(conns) {
+			if n.matches(conn) && conn.signal != 0 && conn.signal < n.MinSignal {
+				weak = append(weak, fmt.Sprintf("%q on %s at %d dBm (min-signal %d dBm)",
+					conn.essid, conn.iface, conn.signal, n.MinSignal))
+			}
+		}
+	}
+	return strings.Join(weak, " and "), nil
+}
+
+// weakSignalExit returns the exit status to use when
+// the wireless signal is too weak.
+func (c *config) weakSignalExit() int {
+	if c.WeakSignalExit == nil {
+		return defaultWeakSignalExit
+	}
+	return *c.WeakSignalExit
+}
@@ -0,0 +1,107 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// wakeSNMP powers on the server's outlet by setting snmp-oid on the PDU at
+// snmp-host to snmp-value using snmpset.
+func wakeSNMP(c *config) error {
+	if c.SNMPHost == "" || c.SNMPOID == "" || c.SNMPValue == "" {
+		return errors.New("snmp wake-mode requires snmp-host, snmp-oid and snmp-value")
+	}
+	typ := c.SNMPType
+	if typ == "" {
+		typ = "i"
+	}
+
+	// Credentials are passed in a private snmp.conf
+	// so that they are not visible in the process list.
+	var conf []string
+	args := []string{"-v", c.snmpVersion()}
+	switch c.snmpVersion() {
+	case "1", "2c":
+		community, err := secret(c.SNMPCommunity, c.SNMPCommunityCommand)
+		if err != nil {
+			return fmt.Errorf("could not get snmp community: %v", err)
+		}
+		if community == "" {
+			community = "private"
+		}
+		conf = append(conf, "defCommunity "+snmpQuote(community))
+	case "3":
+		if c.SNMPUser == "" {
+			return errors.New("snmp version 3 requires snmp-user")
+		}
+		auth, err := secret(c.SNMPAuthPassword, c.SNMPAuthPasswordCommand)
+		if err != nil {
+			return fmt.Errorf("could not get snmp auth password: %v", err)
+		}
+		priv, err := secret(c.SNMPPrivPassword, c.SNMPPrivPasswordCommand)
+		if err != nil {
+			return fmt.Errorf("could not get snmp privacy password: %v", err)
+		}
+		level := "noAuthNoPriv"
+		args = append(args, "-u", c.SNMPUser)
+		if auth != "" {
+			level = "authNoPriv"
+			proto := c.SNMPAuthProtocol
+			if proto == "" {
+				proto = "SHA"
+			}
+			args = append(args, "-a", proto)
+			conf = append(conf, "defAuthPassphrase "+snmpQuote(auth))
+			if priv != "" {
+				level = "authPriv"
+				proto := c.SNMPPrivProtocol
+				if proto == "" {
+					proto = "AES"
+				}
+				args = append(args, "-x", proto)
+				conf = append(conf, "defPrivPassphrase "+snmpQuote(priv))
+			}
+		}
+		args = append(args, "-l", level)
+	default:
+		return fmt.Errorf("unsupported snmp-version: %q", c.SNMPVersion)
+	}
+
+	dir, err := ioutil.TempDir("", "bit-user-callback-snmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "snmp.conf"), []byte(strings.Join(conf, "\n")+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+
+	cmd := toolCommand("snmpset", append(args, c.SNMPHost, c.SNMPOID, typ, c.SNMPValue)...)
+	cmd.Env = append(cmd.Env, "SNMPCONFPATH="+dir)
+	_, err = outputLines(cmd)
+	return err
+}
+
+// snmpVersion returns the configured SNMP protocol version, 2c by default.
+func (c *config) snmpVersion() string {
+	switch v := strings.TrimPrefix(strings.ToLower(c.SNMPVersion), "v"); v {
+	case "", "2":
+		return "2c"
+	default:
+		return v
+	}
+}
+
+// snmpQuote returns s quoted for use as a value in an snmp.conf file.
+func snmpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,84 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// sshCommand returns the arguments to ssh that run cmd on host without
+// prompting for passwords or host key confirmation.
+func sshCommand(host string, cmd ...string) []string {
+	return append([]string{"-o", "BatchMode=yes", "--", host}, cmd...)
+}
+
+// sshProbe returns a readiness probe that succeeds when an ssh connection
+// to the check's address, or the host of the configured server, completes
+// authentication with an agent or the check's identity file and the check's
+// command, if any, exits with status zero on the server.
+func sshProbe(c *config, chk check) (func() error, error) {
+	host, err := chk.host(c)
+	if err != nil {
+		return nil, err
+	}
+	remote := chk.Command
+	if len(remote) == 0 {
+		remote = []string{"true"}
+	}
+	return func() error {
+		timeout := chk.timeout(c)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		args := []string{"-o", "ConnectTimeout=" + strconv.Itoa(int((timeout+time.Second-1)/time.Second))}
+		if chk.Identity != "" {
+			args = append(args, "-i", chk.Identity, "-o", "IdentitiesOnly=yes")
+		}
+		cmd := exec.CommandContext(ctx, "ssh", append(args, sshCommand(host, remote...)...)...)
+		_, err := outputLines(cmd)
+		return err
+	}, nil
+}
+
+// wakeSSH wakes the server by running the configured ssh-relay-command on the
+// configured ssh-relay host over ssh. By default the wake MAC address is given
+// as the command's final argument. If ssh-relay-packet is set, the magic
+// packet is instead written to the command's standard input to be forwarded
+// by the relay.
+func wakeSSH(c *config) error {
+	if c.SSHRelay == "" {
+		return errors.New("ssh wake-mode requires ssh-relay")
+	}
+	hwaddr, err := net.ParseMAC(c.MAC)
+	if err != nil {
+		return fmt.Errorf("could not parse %q as a valid MAC address: %v", c.MAC, err)
+	}
+	relayCmd := c.SSHRelayCommand
+	if c.SSHRelayPacket {
+		if len(relayCmd) == 0 {
+			return errors.New("ssh-relay-packet requires ssh-relay-command")
+		}
+		pass, err := c.wakePassword()
+		if err != nil {
+			return err
+		}
+		cmd := toolCommand("ssh", sshCommand(c.SSHRelay, relayCmd...)...)
+		cmd.Stdin = bytes.NewReader(magicPacket(hwaddr, pass))
+		_, err = outputLines(cmd)
+		return err
+	}
+	if len(relayCmd) == 0 {
+		relayCmd = []string{"wakeonlan"}
+	}
+	args := sshCommand(c.SSHRelay, relayCmd...)
+	_, err = outputLines(toolCommand("ssh", append(args, hwaddr.String())...))
+	return err
+}
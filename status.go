@@ -0,0 +1,189 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// progress is the current phase of operation, reported to
+// clients of the status socket.
+var progress phase
+
+// phase is a named phase of operation. When several targets are woken
+// concurrently, each target's phase is held by a child of the phase for
+// the whole operation so that the phases of all targets are reported.
+type phase struct {
+	mu    sync.Mutex
+	name  string
+	start time.Time
+
+	// parent is the phase holding this target's
+	// phase and is nil for the top-level phase.
+	parent *phase
+
+	// target is the name of the target whose phase
+	// this is and targets is the phase of each
+	// target of a top-level phase.
+	target  string
+	targets []*phase
+}
+
+// begin starts a new timed operation in the named phase, discarding the
+// phases of any targets.
+func (p *phase) begin(name string) {
+	root := p.root()
+	root.mu.Lock()
+	p.name = name
+	p.start = time.Now()
+	if p == root {
+		p.targets = nil
+	}
+	status := root.describe()
+	root.mu.Unlock()
+	sdNotify("STATUS=" + status)
+}
+
+// set sets the name of the current phase without changing
+// the start time of the operation.
+func (p *phase) set(name string) {
+	root := p.root()
+	root.mu.Lock()
+	p.name = name
+	status := root.describe()
+	root.mu.Unlock()
+	sdNotify("STATUS=" + status)
+}
+
+// forTarget returns a new phase for the named target, reported as
+// part of p. The target's phase starts when it begins.
+func (p *phase) forTarget(name string) *phase {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := &phase{parent: p, target: name}
+	p.targets = append(p.targets, t)
+	return t
+}
+
+// root returns the top-level phase holding p.
+func (p *phase) root() *phase {
+	for p.parent != nil {
+		p = p.parent
+	}
+	return p
+}
+
+// describe returns a description of the phase, including the phase of
+// each target, for example "nas.lan: ready; db.lan: waiting for server".
+// It must be called with p.mu held.
+func (p *phase) describe() string {
+	if len(p.targets) == 0 {
+		return p.name
+	}
+	parts := make([]string, 0, len(p.targets))
+	for _, t := range p.targets {
+		if t.name == "" {
+			continue
+		}
+		parts = append(parts, t.target+": "+t.name)
+	}
+	if len(parts) == 0 {
+		return p.name
+	}
+	return strings.Join(parts, "; ")
+}
+
+// message returns the status message for p, including the status of each
+// target.
+func (p *phase) message() statusMessage {
+	root := p.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	msg := statusMessage{Phase: p.describe(), Target: p.target}
+	if !p.start.IsZero() {
+		msg.elapsed = time.Since(p.start)
+	}
+	msg.Elapsed = msg.elapsed.Round(time.Second).String()
+	for _, t := range p.targets {
+		if t.name == "" {
+			continue
+		}
+		tm := statusMessage{Phase: t.name, Target: t.target}
+		if !t.start.IsZero() {
+			tm.elapsed = time.Since(t.start)
+		}
+		tm.Elapsed = tm.elapsed.Round(time.Second).String()
+		msg.Targets = append(msg.Targets, tm)
+	}
+	return msg
+}
+
+// statusMessage is the JSON message sent to status socket clients.
+type statusMessage struct {
+	Target  string          `json:"target,omitempty"`
+	Phase   string          `json:"phase"`
+	Elapsed string          `json:"elapsed"`
+	Targets []statusMessage `json:"targets,omitempty"`
+
+	elapsed time.Duration
+}
+
+// serveStatus listens on a Unix domain socket at path and writes the current
+// phase and elapsed time as a JSON object to each connected client once a
+// second until the client disconnects. A stale socket left at path by an
+// earlier run is replaced, but it is an error for another instance to be
+// listening at path. The socket is closed and removed by exit.
+func serveStatus(path string) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return fmt.Errorf("status socket %s already in use", path)
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			// Remove a stale socket from an earlier run.
+			os.Remove(path)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	atExit(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go streamStatus(conn)
+		}
+	}()
+	return nil
+}
+
+// streamStatus writes status messages to conn once a second until a write
+// fails.
+func streamStatus(conn net.Conn) {
+	defer conn.Close()
+	enc := json.NewEncoder(conn)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		err := enc.Encode(progress.message())
+		if err != nil {
+			return
+		}
+		<-tick.C
+	}
+}
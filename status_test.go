@@ -0,0 +1,98 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPhaseTargets(t *testing.T) {
+	var p phase
+	p.begin("waiting for targets")
+	nas := p.forTarget("nas")
+	db := p.forTarget("db")
+
+	if got, want := p.message().Phase, "waiting for targets"; got != want {
+		t.Errorf("unexpected phase before targets begin: got:%q want:%q", got, want)
+	}
+
+	nas.begin("checking server")
+	db.begin("checking server")
+	nas.set("ready")
+	db.set("waiting for server")
+
+	msg := p.message()
+	if got, want := msg.Phase, "nas: ready; db: waiting for server"; got != want {
+		t.Errorf("unexpected combined phase: got:%q want:%q", got, want)
+	}
+	var got []string
+	for _, m := range msg.Targets {
+		got = append(got, m.Target+"="+m.Phase)
+	}
+	want := []string{"nas=ready", "db=waiting for server"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected target phases: got:%q want:%q", got, want)
+	}
+
+	p.begin("waiting for network change")
+	msg = p.message()
+	if msg.Phase != "waiting for network change" || len(msg.Targets) != 0 {
+		t.Errorf("target phases not discarded: got:%+v", msg)
+	}
+}
+
+func TestServeStatusExisting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bit-user-callback")
+	if err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A socket that a live instance is listening on
+	// must not be taken.
+	live := filepath.Join(dir, "live.sock")
+	l, err := net.Listen("unix", live)
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	defer l.Close()
+	err = serveStatus(live)
+	if err == nil {
+		t.Error("expected error serving status on live socket")
+	}
+	conn, err := net.Dial("unix", live)
+	if err != nil {
+		t.Errorf("live socket removed: %v", err)
+	} else {
+		conn.Close()
+	}
+
+	// A stale socket left by an earlier run is replaced.
+	stale := filepath.Join(dir, "stale.sock")
+	sl, err := net.ListenUnix("unix", &net.UnixAddr{Name: stale, Net: "unix"})
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	sl.SetUnlinkOnClose(false)
+	sl.Close()
+	if _, err := os.Lstat(stale); err != nil {
+		t.Fatalf("stale socket not left: %v", err)
+	}
+	err = serveStatus(stale)
+	if err != nil {
+		t.Errorf("unexpected error serving status on stale socket: %v", err)
+	}
+	conn, err = net.Dial("unix", stale)
+	if err != nil {
+		t.Errorf("status socket not listening: %v", err)
+	} else {
+		conn.Close()
+	}
+}
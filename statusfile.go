@@ -0,0 +1,138 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// statusRecord is the record of the last run held in the status file.
+type statusRecord struct {
+	// Ready is the time the server was last
+	// confirmed to be ready.
+	Ready time.Time `json:"ready"`
+
+	// Targets is the result for each target
+	// in the last run that woke targets.
+	Targets []targetResult `json:"targets,omitempty"`
+}
+
+// readStatusFile returns the status record held in the file at path.
+// A missing file results in a zero record and a nil error.
+func readStatusFile(path string) (statusRecord, error) {
+	var rec statusRecord
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return rec, err
+	}
+	err = json.Unmarshal(b, &rec)
+	return rec, err
+}
+
+// writeStatusFile replaces the status record held in the file at path
+// with rec.
+func writeStatusFile(path string, rec statusRecord) error {
+	b, err := json.MarshalIndent(rec, "", "\t")
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, append(b, '\n'))
+}
+
+// replaceFile replaces the contents of the file at path with b. The file
+// is replaced by renaming a temporary file, so readers see either the old
+// or the new contents.
+func replaceFile(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// probeTargets probes each of the targets of the given networks once,
+// returning the first error.
+func probeTargets(networks []*config) error {
+	targets, err := networkTargets(networks)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		probe, err := readinessProbe(t, nil)
+		if err != nil {
+			return err
+		}
+		err = probe()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recentlyReady returns whether the configured status file records that the
+// server was confirmed ready within the configured recently-ready window.
+func recentlyReady(c *config, info *log.Logger) bool {
+	if c.StatusFile == "" || c.RecentlyReady <= 0 {
+		return false
+	}
+	rec, err := readStatusFile(c.StatusFile)
+	if err != nil {
+		info.Printf("failed to read status file: %v", err)
+		return false
+	}
+	return !rec.Ready.IsZero() && time.Since(rec.Ready) < time.Duration(c.RecentlyReady)
+}
+
+// recordRun records the outcome of the current run in the configured
+// metrics file and status file, if there are any. If ready is true, the
+// current time is recorded in the status file as the time the server was
+// last confirmed ready, otherwise the previously recorded time is retained.
+// The previously recorded target results are retained if results is nil.
+func recordRun(c *config, ready bool, results []targetResult, fatal *log.Logger) {
+	if c.MetricsFile != "" {
+		err := writeMetricsFile(c.MetricsFile, ready, results)
+		if err != nil {
+			fatal.Printf("failed to write metrics file: %v", err)
+		}
+	}
+	if c.StatusFile == "" {
+		return
+	}
+	rec, err := readStatusFile(c.StatusFile)
+	if err != nil {
+		// Replace an unreadable record.
+		rec = statusRecord{}
+	}
+	if ready {
+		rec.Ready = time.Now()
+	}
+	if results != nil {
+		rec.Targets = results
+	}
+	err = writeStatusFile(c.StatusFile, rec)
+	if err != nil {
+		fatal.Printf("failed to write status file: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordRunTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bit-user-callback")
+	if err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config{
+		StatusFile:  filepath.Join(dir, "status.json"),
+		MetricsFile: filepath.Join(dir, "bit-user-callback.prom"),
+	}
+	nas := &config{Server: "http://nas.local/", MAC: "01:23:45:67:89:ab"}
+	backup := &config{Server: "http://backup.local/", MAC: "01:23:45:67:89:ac"}
+	results := []targetResult{
+		newTargetResult(nas, true, 64200*time.Millisecond, nil),
+		newTargetResult(backup, false, 5*time.Minute, errors.New("timed out waiting for http://backup.local/")),
+	}
+
+	var logged bytes.Buffer
+	recordRun(c, false, results, log.New(&logged, "", 0))
+	if logged.Len() != 0 {
+		t.Errorf("unexpected log output: %s", &logged)
+	}
+
+	rec, err := readStatusFile(c.StatusFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading status file: %v", err)
+	}
+	if !rec.Ready.IsZero() {
+		t.Errorf("unexpected ready time for failed run: %v", rec.Ready)
+	}
+	want := []targetResult{
+		{Server: "http://nas.local/", MAC: "01:23:45:67:89:ab", Woken: true, Ready: true, Elapsed: duration(64200 * time.Millisecond)},
+		{Server: "http://backup.local/", MAC: "01:23:45:67:89:ac", Elapsed: duration(5 * time.Minute), Error: "timed out waiting for http://backup.local/"},
+	}
+	if !reflect.DeepEqual(rec.Targets, want) {
+		t.Errorf("unexpected status file targets:\ngot: %+v\nwant:%+v", rec.Targets, want)
+	}
+
+	b, err := ioutil.ReadFile(c.MetricsFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading metrics file: %v", err)
+	}
+	for _, line := range []string{
+		"bit_user_callback_last_run_ready 0",
+		`bit_user_callback_target_ready{server="http://nas.local/",wake_mac="01:23:45:67:89:ab"} 1`,
+		`bit_user_callback_target_woken{server="http://backup.local/",wake_mac="01:23:45:67:89:ac"} 0`,
+		`bit_user_callback_target_error{server="http://backup.local/",wake_mac="01:23:45:67:89:ac"} 1`,
+	} {
+		if !strings.Contains(string(b), line+"\n") {
+			t.Errorf("metrics file missing %q", line)
+		}
+	}
+
+	// A recently ready run without target results
+	// keeps the previous per-target breakdown.
+	recordRun(c, true, nil, log.New(&logged, "", 0))
+	rec, err = readStatusFile(c.StatusFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading status file: %v", err)
+	}
+	if rec.Ready.IsZero() {
+		t.Error("ready time not recorded")
+	}
+	if !reflect.DeepEqual(rec.Targets, want) {
+		t.Errorf("previous targets not retained:\ngot: %+v\nwant:%+v", rec.Targets, want)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// summary is the outcome of the current run, logged as a single line of
+// key=value pairs when the program exits.
+var summary runSummary
+
+// runSummary records the outcome of a run.
+type runSummary struct {
+	mu      sync.Mutex
+	start   time.Time
+	outcome string
+	packets int
+	backup  string
+}
+
+// begin starts recording a run.
+func (s *runSummary) begin() {
+	s.mu.Lock()
+	s.start = time.Now()
+	s.outcome = "incomplete"
+	s.backup = "none"
+	s.mu.Unlock()
+}
+
+// set sets the outcome of the run.
+func (s *runSummary) set(outcome string) {
+	s.mu.Lock()
+	s.outcome = outcome
+	s.mu.Unlock()
+}
+
+// setBackup sets the outcome of the backup command.
+func (s *runSummary) setBackup(outcome string) {
+	s.mu.Lock()
+	s.backup = outcome
+	s.mu.Unlock()
+}
+
+// wakeSent records that a wake packet was sent.
+func (s *runSummary) wakeSent() {
+	s.mu.Lock()
+	s.packets++
+	s.mu.Unlock()
+}
+
+// String returns the summary as key=value pairs.
+func (s *runSummary) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprintf("summary: outcome=%s woken=%t packets=%d elapsed=%v backup=%s",
+		s.outcome, s.packets != 0, s.packets, time.Since(s.start).Round(100*time.Millisecond), s.backup)
+}
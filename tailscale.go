@@ -0,0 +1,125 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// tailscaleStatus is the subset of the output of tailscale status --json
+// used to determine the state of the tailnet.
+type tailscaleStatus struct {
+	BackendState string
+	Peer         map[string]tailscalePeer
+}
+
+// tailscalePeer is a peer in the tailnet.
+type tailscalePeer struct {
+	HostName string
+	DNSName  string
+	Online   bool
+}
+
+// getTailscaleStatus returns the state of the local tailscaled.
+func getTailscaleStatus() (*tailscaleStatus, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := toolCommand("tailscale", "status", "--json")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	// tailscale status exits non-zero when tailscaled
+	// is stopped but still reports its state.
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	var status tailscaleStatus
+	if jerr := json.Unmarshal(stdout.Bytes(), &status); jerr != nil {
+		if err != nil {
+			return nil, fmt.Errorf("tailscale: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return nil, fmt.Errorf("tailscale: invalid status: %v", jerr)
+	}
+	return &status, nil
+}
+
+// online returns whether the tailnet is up and the named peer is online.
+// The peer may be named by its host name or by its full or short MagicDNS
+// name.
+func (s *tailscaleStatus) online(name string) bool {
+	if s.BackendState != "Running" {
+		return false
+	}
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, p := range s.Peer {
+		dns := strings.TrimSuffix(strings.ToLower(p.DNSName), ".")
+		short := dns
+		if i := strings.Index(dns, "."); i >= 0 {
+			short = dns[:i]
+		}
+		if name == strings.ToLower(p.HostName) || name == dns || name == short {
+			return p.Online
+		}
+	}
+	return false
+}
+
+// tailscaleConnected returns whether the tailnet is up and either the
+// configured tailscale-relay or tailscale-peer is online.
+func tailscaleConnected(c *config) (bool, error) {
+	status, err := getTailscaleStatus()
+	if err != nil {
+		return false, err
+	}
+	for _, name := range []string{c.TailscaleRelay, c.TailscalePeer} {
+		if name != "" && status.online(name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// tailscaleProbe returns a probe that succeeds when the check's address, or
+// the configured tailscale-peer, is online in the tailnet.
+func tailscaleProbe(c *config, chk check) (func() error, error) {
+	peer := chk.Address
+	if peer == "" {
+		peer = c.TailscalePeer
+	}
+	if peer == "" {
+		return nil, errors.New("tailscale check requires an address or tailscale-peer")
+	}
+	return func() error {
+		status, err := getTailscaleStatus()
+		if err != nil {
+			return err
+		}
+		if !status.online(peer) {
+			return fmt.Errorf("tailscale peer %s is not online", peer)
+		}
+		return nil
+	}, nil
+}
+
+// wakeTailscale wakes the server by running the configured
+// tailscale-relay-command with the wake MAC address as its final argument
+// on the configured tailscale-relay host using tailscale ssh.
+func wakeTailscale(c *config) error {
+	if c.TailscaleRelay == "" {
+		return errors.New("tailscale wake-mode requires tailscale-relay")
+	}
+	relayCmd := c.TailscaleRelayCommand
+	if len(relayCmd) == 0 {
+		relayCmd = []string{"wakeonlan"}
+	}
+	args := append([]string{"ssh", c.TailscaleRelay}, relayCmd...)
+	_, err := outputLines(toolCommand("tailscale", append(args, c.MAC)...))
+	return err
+}
@@ -0,0 +1,311 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// targetConfigs returns the configuration for each server to be woken. If no
+// targets are configured, the only target is c itself. Otherwise each target
+// is a copy of c with the values in the target's configuration object applied.
+// The name and depends-on values are not inherited from c.
+func targetConfigs(c *config) ([]*config, error) {
+	if len(c.Targets) == 0 {
+		return []*config{c}, nil
+	}
+	base := *c
+	base.Name = ""
+	base.DependsOn = nil
+	targets := make([]*config, len(c.Targets))
+	for i, values := range c.Targets {
+		if _, ok := values["targets"]; ok {
+			return nil, fmt.Errorf("invalid target %d: targets may not be nested", i)
+		}
+		t, err := overlay(&base, values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %d: %v", i, err)
+		}
+		t.Targets = nil
+		targets[i] = t
+	}
+	return targets, nil
+}
+
+// overlay returns a copy of c with the configuration values in values
+// applied. The networks key may not be set in values.
+func overlay(c *config, values map[string]json.RawMessage) (*config, error) {
+	t := *c
+	t.Networks = nil
+	fields := configFields(&t)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field, ok := fields[key]
+		if !ok || key == "networks" {
+			return nil, fmt.Errorf("unknown configuration key %s", key)
+		}
+		err := setField(field, string(values[key]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+	}
+	return &t, nil
+}
+
+// targetResult is the outcome of waking and waiting for a target.
+type targetResult struct {
+	Server  string   `json:"server"`
+	MAC     string   `json:"wake-mac"`
+	Woken   bool     `json:"woken"`
+	Ready   bool     `json:"ready"`
+	Elapsed duration `json:"elapsed"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// wakeAll wakes and waits for each of the targets of the given networks
+// concurrently, with at most the configured concurrency limit of targets
+// being woken and waited for at a time. It returns when all are ready, the
+// timeout configured in c has elapsed or ctx is cancelled. It returns the
+// result for each target and the errors for all targets that did not become
+// ready.
+func wakeAll(ctx context.Context, c *config, networks []*config, info *log.Logger) ([]targetResult, error) {
+	targets, err := networkTargets(networks)
+	if err != nil {
+		return nil, err
+	}
+	deps, err := targetDependencies(targets)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	results := make([]targetResult, len(targets))
+	if len(targets) == 1 {
+		woken, err := wakeAndWait(ctx, targets[0], &progress, info)
+		results[0] = newTargetResult(targets[0], woken, time.Since(start), err)
+		return results, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.Timeout))
+	defer cancel()
+	progress.begin("waiting for targets")
+	phases := make([]*phase, len(targets))
+	for i, t := range targets {
+		phases[i] = progress.forTarget(targetName(t))
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs errorList
+		sem  = make(chan struct{}, c.concurrency())
+
+		// done[i] is closed when target i has finished,
+		// after results[i] has been set.
+		done = make([]chan struct{}, len(targets))
+	)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t *config) {
+			defer wg.Done()
+			defer close(done[i])
+			var (
+				sent bool
+				err  error
+			)
+			for _, d := range deps[i] {
+				select {
+				case <-done[d]:
+					if !results[d].Ready {
+						err = fmt.Errorf("%s not woken: dependency %s not ready", t.Server, targets[d].Name)
+					}
+				case <-ctx.Done():
+					err = fmt.Errorf("%s not woken: %v", t.Server, ctx.Err())
+				}
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				select {
+				case sem <- struct{}{}:
+					sent, err = wakeAndWait(ctx, t, phases[i], info)
+					<-sem
+				case <-ctx.Done():
+					err = fmt.Errorf("%s not woken: %v", t.Server, ctx.Err())
+				}
+			}
+			results[i] = newTargetResult(t, sent, time.Since(start), err)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(i, t)
+	}
+	wg.Wait()
+	if len(errs) != 0 {
+		return results, errs
+	}
+	return results, nil
+}
+
+// targetName returns the name used to report the progress of target t,
+// its name if it has one and otherwise its server.
+func targetName(t *config) string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Server
+}
+
+// targetDependencies returns the indices of the targets that each target
+// depends on. It returns an error if a depends-on name does not match any
+// target or if the dependencies form a cycle.
+func targetDependencies(targets []*config) ([][]int, error) {
+	byName := make(map[string][]int)
+	for i, t := range targets {
+		if t.Name != "" {
+			byName[t.Name] = append(byName[t.Name], i)
+		}
+	}
+	deps := make([][]int, len(targets))
+	for i, t := range targets {
+		for _, name := range t.DependsOn {
+			d, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("invalid depends-on for %s: no target named %q", t.Server, name)
+			}
+			deps[i] = append(deps[i], d...)
+		}
+	}
+
+	// Check for cycles with a depth-first search,
+	// marking targets as visiting or visited.
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make([]int, len(targets))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("invalid depends-on: dependency cycle including %s", targets[i].Server)
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		for _, d := range deps[i] {
+			err := visit(d)
+			if err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+	for i := range targets {
+		err := visit(i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return deps, nil
+}
+
+// newTargetResult returns the result for the target t.
+func newTargetResult(t *config, woken bool, elapsed time.Duration, err error) targetResult {
+	r := targetResult{
+		Server:  t.Server,
+		MAC:     t.MAC,
+		Woken:   woken,
+		Ready:   err == nil,
+		Elapsed: duration(elapsed.Round(time.Millisecond)),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// anyWoken returns whether a wake was sent to any of the targets.
+func anyWoken(results []targetResult) bool {
+	for _, r := range results {
+		if r.Woken {
+			return true
+		}
+	}
+	return false
+}
+
+// networkTargets returns the targets of the given networks with
+// placeholders in their configuration values expanded.
+func networkTargets(networks []*config) ([]*config, error) {
+	var targets []*config
+	for _, n := range networks {
+		nt, err := targetConfigs(n)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range nt {
+			// Expand a copy so that the configured
+			// templates are retained for later use.
+			t := *t
+			err = expandTemplates(&t)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, &t)
+		}
+	}
+	return targets, nil
+}
+
+// concurrency is the default maximum number of targets
+// woken and waited for at a time.
+const concurrency = 4
+
+// concurrency returns the maximum number of targets
+// woken and waited for at a time.
+func (c *config) concurrency() int {
+	if c.Concurrency > 0 {
+		return c.Concurrency
+	}
+	return concurrency
+}
+
+// errorList is a list of errors from targets that did not become ready.
+type errorList []error
+
+func (e errorList) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// sleep pauses for the duration d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
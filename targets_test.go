@@ -0,0 +1,89 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var targetDependenciesTests = []struct {
+	name    string
+	targets []*config
+
+	want    [][]int
+	wantErr string
+}{
+	{
+		name: "none",
+		targets: []*config{
+			{Server: "http://nas.lan/", Name: "nas"},
+			{Server: "http://media.lan/"},
+		},
+		want: [][]int{nil, nil},
+	},
+	{
+		name: "diamond",
+		targets: []*config{
+			{Server: "http://app.lan/", Name: "app", DependsOn: stringList{"db", "cache"}},
+			{Server: "http://db.lan/", Name: "db", DependsOn: stringList{"storage"}},
+			{Server: "http://cache.lan/", Name: "cache", DependsOn: stringList{"storage"}},
+			{Server: "http://storage.lan/", Name: "storage"},
+		},
+		want: [][]int{{1, 2}, {3}, {3}, nil},
+	},
+	{
+		name: "shared name",
+		targets: []*config{
+			{Server: "http://app.lan/", DependsOn: stringList{"storage"}},
+			{Server: "http://nas1.lan/", Name: "storage"},
+			{Server: "http://nas2.lan/", Name: "storage"},
+		},
+		want: [][]int{{1, 2}, nil, nil},
+	},
+	{
+		name: "self",
+		targets: []*config{
+			{Server: "http://nas.lan/", Name: "nas", DependsOn: stringList{"nas"}},
+		},
+		wantErr: "dependency cycle including http://nas.lan/",
+	},
+	{
+		name: "cycle",
+		targets: []*config{
+			{Server: "http://app.lan/", Name: "app", DependsOn: stringList{"db"}},
+			{Server: "http://db.lan/", Name: "db", DependsOn: stringList{"app"}},
+		},
+		wantErr: "dependency cycle including http://app.lan/",
+	},
+	{
+		name: "unknown",
+		targets: []*config{
+			{Server: "http://app.lan/", Name: "app", DependsOn: stringList{"database"}},
+			{Server: "http://db.lan/", Name: "db"},
+		},
+		wantErr: `invalid depends-on for http://app.lan/: no target named "database"`,
+	},
+}
+
+func TestTargetDependencies(t *testing.T) {
+	for _, test := range targetDependenciesTests {
+		got, err := targetDependencies(test.targets)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("unexpected error for %s: got:%v want:%q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected dependencies for %s: got:%v want:%v", test.name, got, test.want)
+		}
+	}
+}
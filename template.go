@@ -0,0 +1,110 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// placeholders are the placeholder names that may be used in templated
+// configuration values.
+var placeholders = []string{"essid", "interface", "mac"}
+
+// expandTemplates replaces the placeholders {essid}, {interface} and {mac}
+// in the server, wake address and webhook values of c with the connected
+// configured ESSID, and the name and hardware address of the wireless
+// interface connected to it. Values without placeholders are left unchanged.
+func expandTemplates(c *config) error {
+	fields := []struct {
+		key string
+		val *string
+	}{
+		{"server", &c.Server},
+		{"wake-remote", &c.Remote},
+		{"wake-local", &c.Local},
+		{"wake-unicast", &c.WakeUnicast},
+		{"webhook-url", &c.WebhookURL},
+		{"webhook-body", &c.WebhookBody},
+	}
+	values := make(map[string]string)
+	for _, f := range fields {
+		for _, name := range placeholders {
+			p := "{" + name + "}"
+			if !strings.Contains(*f.val, p) {
+				continue
+			}
+			v, ok := values[name]
+			if !ok {
+				var err error
+				v, err = placeholder(c, name)
+				if err != nil {
+					return fmt.Errorf("cannot expand %s in %s: %v", p, f.key, err)
+				}
+				values[name] = v
+			}
+			*f.val = strings.ReplaceAll(*f.val, p, v)
+		}
+	}
+	return nil
+}
+
+// placeholder returns the value of the named placeholder for c.
+func placeholder(c *config, name string) (string, error) {
+	switch {
+	case !c.hasESSIDs():
+		return "", fmt.Errorf("essid not configured")
+	case name != "essid":
+	case len(c.ESSID) == 1 && c.ESSIDPattern == "":
+		return c.ESSID[0], nil
+	default:
+		essid, err := connectedESSID(c)
+		if err != nil {
+			return "", err
+		}
+		if essid == "" {
+			return "", fmt.Errorf("not connected to %s", trustedNetwork(c))
+		}
+		return essid, nil
+	}
+	iface, err := connectedInterface(c)
+	if err != nil {
+		return "", err
+	}
+	if name == "interface" {
+		return iface, nil
+	}
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return "", err
+	}
+	if len(i.HardwareAddr) == 0 {
+		return "", fmt.Errorf("%s has no hardware address", iface)
+	}
+	return i.HardwareAddr.String(), nil
+}
+
+// connectedInterface returns the name of the wireless interface connected
+// to a configured ESSID.
+func connectedInterface(c *config) (string, error) {
+	b, err := lookupEssidBackend(c.EssidBackend)
+	if err != nil {
+		return "", err
+	}
+	if b.connections == nil {
+		return "", fmt.Errorf("essid-backend %s cannot determine interfaces", c.EssidBackend)
+	}
+	conns, err := b.connections(c)
+	if err != nil {
+		return "", err
+	}
+	for _, conn := range c.allowedConnections(conns) {
+		if c.matches(conn) {
+			return conn.iface, nil
+		}
+	}
+	return "", fmt.Errorf("no interface connected to %s", trustedNetwork(c))
+}
@@ -0,0 +1,81 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uptimeProbe returns a probe that succeeds when the server reports that it
+// has been up for at least the configured min-uptime. The server's uptime is
+// obtained from the output of uptime-command or the body of a GET request to
+// uptime-url, the first field of which must be the uptime in seconds, as
+// found in /proc/uptime.
+func uptimeProbe(c *config) (func() error, error) {
+	var uptime func() (string, error)
+	switch {
+	case len(c.UptimeCommand) != 0 && c.UptimeURL != "":
+		return nil, errors.New("only one of uptime-command and uptime-url may be set")
+	case len(c.UptimeCommand) != 0:
+		uptime = func() (string, error) {
+			lines, err := commandLines(c.UptimeCommand)
+			return strings.Join(lines, "\n"), err
+		}
+	case c.UptimeURL != "":
+		client, err := probeClient(c)
+		if err != nil {
+			return nil, err
+		}
+		uptime = func() (string, error) {
+			resp, err := client.Get(c.UptimeURL)
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return "", fmt.Errorf("uptime request returned %s", resp.Status)
+			}
+			b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+			return string(b), err
+		}
+	default:
+		return nil, errors.New("min-uptime requires uptime-command or uptime-url")
+	}
+	return func() error {
+		text, err := uptime()
+		if err != nil {
+			return err
+		}
+		up, err := parseUptime(text)
+		if err != nil {
+			return err
+		}
+		if up < time.Duration(c.MinUptime) {
+			return fmt.Errorf("server up for %v, less than min-uptime %v", up.Round(time.Second), time.Duration(c.MinUptime))
+		}
+		return nil
+	}, nil
+}
+
+// parseUptime returns the uptime held in the first field of text
+// as a number of seconds.
+func parseUptime(text string) (time.Duration, error) {
+	f := strings.Fields(text)
+	if len(f) == 0 {
+		return 0, errors.New("empty uptime")
+	}
+	sec, err := strconv.ParseFloat(f[0], 64)
+	if err != nil || sec < 0 {
+		return 0, fmt.Errorf("invalid uptime: %q", f[0])
+	}
+	return time.Duration(sec * float64(time.Second)), nil
+}
@@ -0,0 +1,410 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// checkConfig writes any errors and warnings about the configuration to w and
+// returns whether the configuration is free of errors.
+func checkConfig(c *config, w io.Writer) bool {
+	var (
+		errs     []error
+		warnings []string
+	)
+
+	backend, err := lookupEssidBackend(c.EssidBackend)
+	switch {
+	case err != nil:
+		errs = append(errs, err)
+	case c.EssidBackend == "iwconfig" || c.EssidBackend == "" && defaultEssidBackend == "iwconfig":
+		path := c.Iwconfig
+		if path == "" {
+			path = iwconfig
+		}
+		warnings = append(warnings, missing([]string{path})...)
+	case c.EssidBackend == "nmcli":
+		warnings = append(warnings, missing([]string{c.nmcliPath()})...)
+	default:
+		warnings = append(warnings, missing(backend.requires)...)
+	}
+	if len(c.Interfaces) != 0 && err == nil && backend.connections == nil {
+		errs = append(errs, errors.New("interfaces requires an essid-backend that reports interfaces"))
+	}
+	switch c.ConnectivityCheck {
+	case "", "http":
+	case "nmcli":
+		warnings = append(warnings, missing([]string{c.nmcliPath()})...)
+	default:
+		errs = append(errs, fmt.Errorf("invalid connectivity-check: %q", c.ConnectivityCheck))
+	}
+	switch c.NetworkSelect {
+	case "", "first", "all", "error":
+	default:
+		errs = append(errs, fmt.Errorf("invalid network-select: %q", c.NetworkSelect))
+	}
+	networks, err := networkConfigs(c)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for i, n := range networks {
+		if n.ConnectionUUID != "" {
+			if _, err := n.uuidBackend(); err != nil {
+				if len(networks) > 1 {
+					err = fmt.Errorf("network %d: %v", i, err)
+				}
+				errs = append(errs, err)
+			}
+		}
+		targets, err := targetConfigs(n)
+		if err != nil {
+			errs = append(errs, err)
+		} else if len(n.Targets) == 0 {
+			if len(n.DependsOn) != 0 {
+				errs = append(errs, errors.New("depends-on may only be set in targets"))
+			}
+		} else if _, err := targetDependencies(targets); err != nil {
+			errs = append(errs, err)
+		}
+		for j, t := range targets {
+			terrs, twarnings := checkTarget(t)
+			var label []string
+			if len(networks) > 1 {
+				label = append(label, fmt.Sprintf("network %d", i))
+			}
+			if len(targets) > 1 {
+				label = append(label, fmt.Sprintf("target %d", j))
+			}
+			if label != nil {
+				prefix := strings.Join(label, " ")
+				for k, err := range terrs {
+					terrs[k] = fmt.Errorf("%s: %v", prefix, err)
+				}
+				for k, warn := range twarnings {
+					twarnings[k] = fmt.Sprintf("%s: %s", prefix, warn)
+				}
+			}
+			errs = append(errs, terrs...)
+			warnings = append(warnings, twarnings...)
+		}
+	}
+
+	for _, err := range errs {
+		fmt.Fprintf(w, "error: %v\n", err)
+	}
+	for _, warn := range warnings {
+		fmt.Fprintf(w, "warning: %s\n", warn)
+	}
+	return len(errs) == 0
+}
+
+// checkTarget returns errors and warnings about the readiness checks and
+// wake settings for the server configured in c.
+func checkTarget(c *config) (errs []error, warnings []string) {
+	var required func(l checks)
+	required = func(l checks) {
+		for _, chk := range l {
+			s, err := lookupServerCheck(chk.Type)
+			if err == nil {
+				warnings = append(warnings, missing(s.requires)...)
+			}
+			required(chk.Checks)
+		}
+	}
+	required(c.ServerCheck)
+	if c.ServerService != "" {
+		warnings = append(warnings, missing([]string{"avahi-browse"})...)
+	}
+	switch c.WakeConfirm {
+	case "":
+		probed := c
+		if c.ServerService != "" {
+			// Check the probes that would be made against
+			// a discovered server using a documentation
+			// address in its place.
+			found := *c
+			found.Server = discoveredServer(c.Server, "192.0.2.1", "1")
+			found.ServerService = ""
+			probed = &found
+		}
+		_, err := readinessProbe(probed, nil)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case "inbound":
+		if c.ConfirmListen == "" {
+			errs = append(errs, errors.New("inbound wake-confirm requires wake-confirm-listen"))
+		} else if _, _, err := net.SplitHostPort(c.ConfirmListen); err != nil {
+			errs = append(errs, fmt.Errorf("invalid wake-confirm-listen: %v", err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid wake-confirm: %q", c.WakeConfirm))
+	}
+	for _, name := range c.wakeModeNames() {
+		mode, err := lookupWakeMode(name)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			warnings = append(warnings, missing(mode.requires)...)
+		}
+	}
+	if c.FallbackPolls < 0 {
+		errs = append(errs, fmt.Errorf("invalid wake-fallback-polls: %d", c.FallbackPolls))
+	}
+	if c.WakeRepeat < 0 {
+		errs = append(errs, fmt.Errorf("invalid wake-repeat: %d", c.WakeRepeat))
+	}
+	if c.usesWakeMode("ssh") && c.SSHRelay == "" {
+		errs = append(errs, errors.New("ssh wake-mode requires ssh-relay"))
+	}
+	if c.SSHRelayPacket && len(c.SSHRelayCommand) == 0 {
+		errs = append(errs, errors.New("ssh-relay-packet requires ssh-relay-command"))
+	}
+	if c.usesWakeMode("ipmi") && c.IPMIHost == "" {
+		errs = append(errs, errors.New("ipmi wake-mode requires ipmi-host"))
+	}
+	if c.usesWakeMode("redfish") && c.RedfishURL == "" {
+		errs = append(errs, errors.New("redfish wake-mode requires redfish-url"))
+	}
+	if c.RedfishFingerprint != "" {
+		if _, err := pinnedTLSConfig("redfish", c.RedfishFingerprint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.usesWakeMode("amt") && c.AMTHost == "" {
+		errs = append(errs, errors.New("amt wake-mode requires amt-host"))
+	}
+	if c.AMTFingerprint != "" {
+		if _, err := pinnedTLSConfig("amt", c.AMTFingerprint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.usesWakeMode("ec2") && c.EC2Instance == "" {
+		errs = append(errs, errors.New("ec2 wake-mode requires ec2-instance"))
+	}
+	if c.usesWakeMode("gce") && c.GCEInstance == "" {
+		errs = append(errs, errors.New("gce wake-mode requires gce-instance"))
+	}
+	if c.usesWakeMode("azure") && (c.AzureVM == "" || c.AzureResourceGroup == "") {
+		errs = append(errs, errors.New("azure wake-mode requires azure-vm and azure-resource-group"))
+	}
+	if c.usesWakeMode("proxmox") && (c.ProxmoxURL == "" || c.ProxmoxNode == "" || c.ProxmoxVMID == 0) {
+		errs = append(errs, errors.New("proxmox wake-mode requires proxmox-url, proxmox-node and proxmox-vmid"))
+	}
+	if c.ProxmoxFingerprint != "" {
+		if _, err := pinnedTLSConfig("proxmox", c.ProxmoxFingerprint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.usesWakeMode("libvirt") && c.LibvirtDomain == "" {
+		errs = append(errs, errors.New("libvirt wake-mode requires libvirt-domain"))
+	}
+	if c.usesWakeMode("mqtt") && (c.MQTTBroker == "" || c.MQTTTopic == "") {
+		errs = append(errs, errors.New("mqtt wake-mode requires mqtt-broker and mqtt-topic"))
+	}
+	if c.MQTTFingerprint != "" {
+		if _, err := pinnedTLSConfig("mqtt broker", c.MQTTFingerprint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.usesWakeMode("http") && c.WebhookURL == "" {
+		errs = append(errs, errors.New("http wake-mode requires webhook-url"))
+	}
+	if c.WebhookFingerprint != "" {
+		if _, err := pinnedTLSConfig("webhook", c.WebhookFingerprint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.usesWakeMode("snmp") {
+		if c.SNMPHost == "" || c.SNMPOID == "" || c.SNMPValue == "" {
+			errs = append(errs, errors.New("snmp wake-mode requires snmp-host, snmp-oid and snmp-value"))
+		}
+		switch c.snmpVersion() {
+		case "1", "2c":
+		case "3":
+			if c.SNMPUser == "" {
+				errs = append(errs, errors.New("snmp version 3 requires snmp-user"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unsupported snmp-version: %q", c.SNMPVersion))
+		}
+	}
+	if c.usesWakeMode("raw") && c.WakeInterface == "" {
+		errs = append(errs, errors.New("raw wake-mode requires wake-interface"))
+	}
+
+	for _, b := range c.BSSID {
+		if _, err := net.ParseMAC(b); err != nil {
+			errs = append(errs, fmt.Errorf("invalid bssid: %v", err))
+		}
+	}
+	if len(c.BSSID) != 0 {
+		if b, err := lookupEssidBackend(c.EssidBackend); err == nil && b.connections == nil {
+			errs = append(errs, errors.New("bssid requires an essid-backend that reports access points"))
+		}
+	}
+
+	if _, err := c.essidPattern(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, e := range c.DenyESSIDs {
+		if c.trustedESSID(e) {
+			warnings = append(warnings, fmt.Sprintf("denied ESSID %q is also trusted and will never be used", e))
+		}
+	}
+
+	if c.MinSignal > 0 {
+		errs = append(errs, fmt.Errorf("invalid min-signal: %d dBm is not a negative level", c.MinSignal))
+	}
+	if c.MinSignal != 0 {
+		if b, err := lookupEssidBackend(c.EssidBackend); err == nil && b.connections == nil || c.EssidBackend == "iwd" {
+			warnings = append(warnings, fmt.Sprintf("min-signal has no effect with the %s essid-backend", c.EssidBackend))
+		}
+	}
+
+	for _, mac := range c.WiredGateway {
+		if _, err := net.ParseMAC(mac); err != nil {
+			errs = append(errs, fmt.Errorf("invalid wired-gateway-mac: %v", err))
+		}
+	}
+	for _, s := range c.WiredSubnet {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			errs = append(errs, fmt.Errorf("invalid wired-subnet: %v", err))
+		}
+	}
+	for _, s := range c.VPNAllowedIPs {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			errs = append(errs, fmt.Errorf("invalid vpn-allowed-ips: %v", err))
+		}
+	}
+	if c.VPNInterface == "" && (len(c.VPNEndpoint) != 0 || len(c.VPNAllowedIPs) != 0) {
+		errs = append(errs, errors.New("vpn-endpoint and vpn-allowed-ips require vpn-interface"))
+	}
+	if c.VPNInterface != "" && (len(c.VPNEndpoint) != 0 || len(c.VPNAllowedIPs) != 0) {
+		warnings = append(warnings, missing([]string{"wg"})...)
+	}
+	for _, mac := range c.TrustedGateways {
+		if _, err := net.ParseMAC(mac); err != nil {
+			errs = append(errs, fmt.Errorf("invalid trusted-gateway-macs: %v", err))
+		}
+	}
+	for _, s := range c.TrustedSubnets {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			errs = append(errs, fmt.Errorf("invalid trusted-subnets: %v", err))
+		}
+	}
+	if c.LocationHost != "" && len(c.LocationSubnets) == 0 {
+		errs = append(errs, errors.New("location-host requires location-subnets"))
+	}
+	for _, s := range c.LocationSubnets {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			errs = append(errs, fmt.Errorf("invalid location-subnets: %v", err))
+		}
+	}
+
+	_, err := net.ParseMAC(c.MAC)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid wake-mac: %v", err))
+	}
+	_, err = c.wakePassword()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	network, err := wakeNetwork(c)
+	if err != nil {
+		errs = append(errs, err)
+		network = "udp"
+	}
+	var raddr *net.UDPAddr
+	err = checkFamily(network, "wake-remote", c.Remote)
+	if err != nil {
+		errs = append(errs, err)
+	} else if raddr, err = resolveUDPAddr(c, network, c.Remote); err != nil {
+		errs = append(errs, fmt.Errorf("invalid wake-remote: %v", err))
+	}
+	if c.Local != "" {
+		err = checkFamily(network, "wake-local", c.Local)
+		if err != nil {
+			errs = append(errs, err)
+		} else if laddr, err := resolveUDPAddr(c, network, c.Local); err != nil {
+			errs = append(errs, fmt.Errorf("invalid wake-local: %v", err))
+		} else if raddr != nil {
+			err = sameFamily(laddr, raddr)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if warn := routedBroadcastWarning(c); warn != "" {
+		warnings = append(warnings, warn)
+	}
+	return errs, warnings
+}
+
+// missing returns warnings for each of the required programs that cannot be
+// found in $PATH.
+func missing(progs []string) []string {
+	var warnings []string
+	for _, p := range progs {
+		_, err := exec.LookPath(p)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("required program %s not found", p))
+		}
+	}
+	return warnings
+}
+
+// routedBroadcastWarning returns a warning if the configured wake-remote
+// address is the limited broadcast address but the server does not appear to
+// be on a subnet of any local interface. Limited broadcasts are not forwarded
+// by routers, so in this case the wake packet is unlikely to reach the server.
+// If no problem is detected, or the check cannot be made, the empty string is
+// returned.
+func routedBroadcastWarning(c *config) string {
+	raddr, err := resolveUDPAddr(c, "udp", c.Remote)
+	if err != nil || !raddr.IP.Equal(net.IPv4bcast) {
+		return ""
+	}
+	u, err := url.Parse(c.Server)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	ips, err := lookupIP(c, u.Hostname())
+	if err != nil {
+		return ""
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	var server net.IP
+	for _, ip := range ips {
+		ip = ip.To4()
+		if ip == nil {
+			continue
+		}
+		server = ip
+		for _, a := range addrs {
+			n, ok := a.(*net.IPNet)
+			if ok && !n.IP.IsLoopback() && n.Contains(ip) {
+				return ""
+			}
+		}
+	}
+	if server == nil {
+		return ""
+	}
+	directed := net.IPv4(server[0], server[1], server[2], 255)
+	return fmt.Sprintf("wake-remote is the limited broadcast address but server %s (%s) is not on a local subnet; "+
+		"routers do not forward limited broadcasts, so consider a directed broadcast address for the server's subnet such as %s:%d for a /24 network",
+		u.Hostname(), server, directed, raddr.Port)
+}
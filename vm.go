@@ -0,0 +1,100 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// wakeProxmox starts the configured virtual machine or container using the
+// Proxmox VE API at proxmox-url, authenticating with an API token. A guest
+// that is already running is not started again.
+func wakeProxmox(c *config) error {
+	if c.ProxmoxURL == "" || c.ProxmoxNode == "" || c.ProxmoxVMID == 0 {
+		return errors.New("proxmox wake-mode requires proxmox-url, proxmox-node and proxmox-vmid")
+	}
+	secretValue, err := secret(c.ProxmoxSecret, c.ProxmoxSecretCommand)
+	if err != nil {
+		return fmt.Errorf("could not get proxmox secret: %v", err)
+	}
+	client, err := tlsClient(c, "proxmox", c.ProxmoxFingerprint, c.ProxmoxInsecure)
+	if err != nil {
+		return err
+	}
+	typ := c.ProxmoxType
+	if typ == "" {
+		typ = "qemu"
+	}
+	guest := strings.TrimSuffix(c.ProxmoxURL, "/") + "/api2/json/nodes/" + c.ProxmoxNode + "/" + typ + "/" + strconv.Itoa(c.ProxmoxVMID)
+	do := func(method, url string) ([]byte, error) {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "PVEAPIToken="+c.ProxmoxToken+"="+secretValue)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("proxmox %s %s: %s: %s", method, url, resp.Status, bytes.TrimSpace(body))
+		}
+		return body, nil
+	}
+
+	body, err := do(http.MethodGet, guest+"/status/current")
+	if err != nil {
+		return err
+	}
+	var current struct {
+		Data struct {
+			Status string `json:"status"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(body, &current)
+	if err != nil {
+		return fmt.Errorf("proxmox: invalid status: %v", err)
+	}
+	if current.Data.Status == "running" {
+		return nil
+	}
+	_, err = do(http.MethodPost, guest+"/status/start")
+	return err
+}
+
+// wakeLibvirt starts the configured libvirt domain using virsh, connecting
+// to libvirt-uri, such as qemu+ssh://host/system, if it is set. A domain that
+// is already running is not started again.
+func wakeLibvirt(c *config) error {
+	if c.LibvirtDomain == "" {
+		return errors.New("libvirt wake-mode requires libvirt-domain")
+	}
+	var args []string
+	if c.LibvirtURI != "" {
+		args = append(args, "--connect", c.LibvirtURI)
+	}
+	state, err := outputLines(toolCommand("virsh", append(args, "domstate", c.LibvirtDomain)...))
+	if err != nil {
+		return err
+	}
+	if len(state) != 0 && state[0] == "running" {
+		return nil
+	}
+	_, err = outputLines(toolCommand("virsh", append(args, "start", c.LibvirtDomain)...))
+	return err
+}
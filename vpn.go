@@ -0,0 +1,115 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// vpnConnected returns whether the configured VPN interface is up and, if
+// vpn-endpoint or vpn-allowed-ips are set, whether it has a WireGuard peer
+// with one of the configured endpoints or with allowed IPs covering one of
+// the configured ranges.
+func vpnConnected(c *config) (bool, error) {
+	iface, err := net.InterfaceByName(c.VPNInterface)
+	if err != nil || iface.Flags&net.FlagUp == 0 {
+		// A missing interface is a VPN that is not up.
+		return false, nil
+	}
+	if len(c.VPNEndpoint) == 0 && len(c.VPNAllowedIPs) == 0 {
+		return true, nil
+	}
+	if len(c.VPNEndpoint) != 0 {
+		peers, err := wgShow(c.VPNInterface, "endpoints")
+		if err != nil {
+			return false, err
+		}
+		for _, endpoints := range peers {
+			for _, e := range endpoints {
+				for _, want := range c.VPNEndpoint {
+					if sameEndpoint(want, e) {
+						return true, nil
+					}
+				}
+			}
+		}
+	}
+	if len(c.VPNAllowedIPs) != 0 {
+		peers, err := wgShow(c.VPNInterface, "allowed-ips")
+		if err != nil {
+			return false, err
+		}
+		for _, allowed := range peers {
+			for _, a := range allowed {
+				_, got, err := net.ParseCIDR(a)
+				if err != nil {
+					continue
+				}
+				for _, want := range c.VPNAllowedIPs {
+					_, w, err := net.ParseCIDR(want)
+					if err != nil {
+						return false, fmt.Errorf("invalid vpn-allowed-ips: %v", err)
+					}
+					if covers(got, w) {
+						return true, nil
+					}
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// wgShow returns the values of the named per-peer field of the WireGuard
+// interface iface reported by wg show, keyed by peer public key.
+func wgShow(iface, field string) (map[string][]string, error) {
+	lines, err := outputLines(toolCommand("wg", "show", iface, field))
+	if err != nil {
+		return nil, err
+	}
+	peers := make(map[string][]string)
+	for _, l := range lines {
+		f := strings.Fields(l)
+		if len(f) < 2 {
+			continue
+		}
+		for _, v := range f[1:] {
+			if v != "(none)" {
+				peers[f[0]] = append(peers[f[0]], v)
+			}
+		}
+	}
+	return peers, nil
+}
+
+// sameEndpoint returns whether the endpoint got matches want. If want has
+// no port, only the hosts are compared.
+func sameEndpoint(want, got string) bool {
+	gotHost, gotPort, err := net.SplitHostPort(got)
+	if err != nil {
+		return false
+	}
+	wantHost, wantPort, err := net.SplitHostPort(want)
+	if err != nil {
+		wantHost, wantPort = strings.Trim(want, "[]"), ""
+	}
+	if wantPort != "" && wantPort != gotPort {
+		return false
+	}
+	wantIP, gotIP := net.ParseIP(wantHost), net.ParseIP(gotHost)
+	if wantIP == nil || gotIP == nil {
+		return wantHost == gotHost
+	}
+	return wantIP.Equal(gotIP)
+}
+
+// covers returns whether the network a includes all of the network b.
+func covers(a, b *net.IPNet) bool {
+	aOnes, aBits := a.Mask.Size()
+	bOnes, bBits := b.Mask.Size()
+	return aBits == bBits && aOnes <= bOnes && a.Contains(b.IP)
+}
@@ -0,0 +1,409 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// wakeUDP sends a Wake-On-LAN magic packet over UDP using the configured
+// addresses.
+//
+// If a wake interface is configured, the packet is sent from a socket bound to
+// that interface and from the interface's address if it has one. If the
+// interface is a WireGuard device, the packet is sent to the
+// configured unicast address rather than to the wake-remote address, since
+// broadcasts are not carried by WireGuard tunnels.
+func wakeUDP(c *config) error {
+	network, err := wakeNetwork(c)
+	if err != nil {
+		return err
+	}
+	local, remote := c.Local, c.Remote
+	if c.WakeInterface != "" {
+		_, err := net.InterfaceByName(c.WakeInterface)
+		if err != nil {
+			return fmt.Errorf("invalid wake-interface %q: %v", c.WakeInterface, err)
+		}
+		// An interface without an address, such as an
+		// unnumbered VLAN, is selected by binding alone.
+		local = ""
+		ip, err := interfaceIP(c.WakeInterface, network == "udp4")
+		if err == nil {
+			local = net.JoinHostPort(ip.String(), "0")
+		}
+		if isWireGuard(c.WakeInterface) {
+			if c.WakeUnicast == "" {
+				return fmt.Errorf("wake-interface %s is a WireGuard device but wake-unicast is not set", c.WakeInterface)
+			}
+			remote = c.WakeUnicast
+		}
+	}
+	if c.WakeInterface == "" && local == "" && network == "udp4" && c.wakeAllInterfaces() {
+		raddr, err := resolveUDPAddr(c, network, remote)
+		if err == nil && raddr.IP.Equal(net.IPv4bcast) {
+			return wakeBroadcasts(c, network, remote, raddr.Port)
+		}
+	}
+	return wake(c, network, c.MAC, local, remote)
+}
+
+// errNoBindToDevice is returned when sockets cannot be bound to an interface.
+var errNoBindToDevice = errors.New("cannot bind to device")
+
+// wakeBound sends the magic packet to raddr from a UDP socket bound to the
+// named interface and, if laddr is not nil, to the local address laddr.
+func wakeBound(iface, network string, laddr, raddr *net.UDPAddr, packet []byte) error {
+	d := net.Dialer{Control: bindToDevice(iface)}
+	if laddr != nil {
+		d.LocalAddr = laddr
+	}
+	conn, err := d.Dial(network, raddr.String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	n, err := conn.Write(packet)
+	if err != nil {
+		return err
+	}
+	if n < len(packet) {
+		return io.ErrShortWrite
+	}
+	return nil
+}
+
+// wakeAllInterfaces is the default for whether wake packets sent to
+// the limited broadcast address are instead sent to the directed
+// broadcast address of each interface.
+var wakeAllInterfaces = true
+
+// wakeAllInterfaces returns whether wake packets sent to the limited
+// broadcast address are instead sent to the directed broadcast address
+// of each interface.
+func (c *config) wakeAllInterfaces() bool {
+	if c.WakeAllInterfaces == nil {
+		return wakeAllInterfaces
+	}
+	return *c.WakeAllInterfaces
+}
+
+// wakeBroadcasts sends a wake packet from each IPv4 address of every up,
+// non-loopback, broadcast-capable interface to the directed broadcast
+// address of that address's subnet on the given port. Some wireless drivers
+// drop packets sent to the limited broadcast address. If there are no such
+// interfaces, the packet is sent to remote. It succeeds if any packet is
+// sent.
+func wakeBroadcasts(c *config, network, remote string, port int) error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+	var (
+		sent     bool
+		firstErr error
+	)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			n, ok := a.(*net.IPNet)
+			if !ok || n.IP.To4() == nil || len(n.Mask) != net.IPv4len {
+				continue
+			}
+			ip := n.IP.To4()
+			bcast := make(net.IP, net.IPv4len)
+			for i := range bcast {
+				bcast[i] = ip[i] | ^n.Mask[i]
+			}
+			local := net.JoinHostPort(ip.String(), "0")
+			err = wake(c, network, c.MAC, local, net.JoinHostPort(bcast.String(), strconv.Itoa(port)))
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %v", iface.Name, err)
+				}
+				continue
+			}
+			sent = true
+		}
+	}
+	if sent {
+		return nil
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return wake(c, network, c.MAC, "", remote)
+}
+
+// wakeRepeatGap is the time between the repeated
+// wake packets sent for a single wake attempt.
+const wakeRepeatGap = 100 * time.Millisecond
+
+// sendWake sends wake-repeat wake packets using mode, one by default,
+// recording each packet sent in the run summary.
+func sendWake(ctx context.Context, c *config, mode wakeMode) error {
+	n := c.WakeRepeat
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		if i != 0 {
+			sleep(ctx, wakeRepeatGap)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+		err := mode.wake(c)
+		if err != nil {
+			return err
+		}
+		summary.wakeSent()
+	}
+	return nil
+}
+
+// defaultFallbackPolls is the number of failed readiness
+// probes after a wake before the next wake-fallback mode
+// is used.
+const defaultFallbackPolls = 5
+
+// wakeChain is the configured wake-mode followed by the
+// wake-fallback modes, tried in order until one succeeds.
+type wakeChain struct {
+	names []string
+	modes []wakeMode
+	i     int
+}
+
+// newWakeChain returns the wake chain for c.
+func newWakeChain(c *config) (*wakeChain, error) {
+	var w wakeChain
+	for _, name := range c.wakeModeNames() {
+		m, err := lookupWakeMode(name)
+		if err != nil {
+			return nil, err
+		}
+		w.names = append(w.names, name)
+		w.modes = append(w.modes, m)
+	}
+	return &w, nil
+}
+
+// wakeModeNames returns the names of the configured wake-mode and
+// wake-fallback modes in the order they are tried.
+func (c *config) wakeModeNames() []string {
+	name := c.WakeMode
+	if name == "" {
+		name = defaultWakeMode
+	}
+	return append([]string{name}, c.WakeFallback...)
+}
+
+// usesWakeMode returns whether name is the wake-mode or one of the
+// wake-fallback modes of c.
+func (c *config) usesWakeMode(name string) bool {
+	for _, n := range c.wakeModeNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackPolls returns the number of failed readiness probes after
+// a wake before falling back to the next wake mode.
+func (c *config) fallbackPolls() int {
+	if c.FallbackPolls < 1 {
+		return defaultFallbackPolls
+	}
+	return c.FallbackPolls
+}
+
+// name returns the name of the current wake mode.
+func (w *wakeChain) name() string {
+	return w.names[w.i]
+}
+
+// fallback advances to the next wake mode, returning false if there
+// are no more modes.
+func (w *wakeChain) fallback() bool {
+	if w.i+1 >= len(w.modes) {
+		return false
+	}
+	w.i++
+	return true
+}
+
+// send sends a wake using the current wake mode. If sending fails and
+// there is a fallback mode, the failure is logged and the next mode is
+// tried.
+func (w *wakeChain) send(ctx context.Context, c *config, info *log.Logger) error {
+	for {
+		err := sendWake(ctx, c, w.modes[w.i])
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		failed := w.name()
+		if !w.fallback() {
+			return err
+		}
+		info.Printf("%s wake-mode failed for %s: %v: falling back to %s", failed, c.Server, err, w.name())
+	}
+}
+
+// etherTypeWOL is the EtherType of Wake-On-LAN ethernet frames.
+const etherTypeWOL = 0x0842
+
+// wakeRaw sends a Wake-On-LAN magic packet as a broadcast raw ethernet
+// frame from the configured wake-interface.
+func wakeRaw(c *config) error {
+	if c.WakeInterface == "" {
+		return errors.New("raw wake-mode requires wake-interface")
+	}
+	iface, err := net.InterfaceByName(c.WakeInterface)
+	if err != nil {
+		return fmt.Errorf("invalid wake-interface %q: %v", c.WakeInterface, err)
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return fmt.Errorf("wake-interface %s is not an ethernet interface", c.WakeInterface)
+	}
+	hwaddr, err := net.ParseMAC(c.MAC)
+	if err != nil || len(hwaddr) != 6 {
+		return fmt.Errorf("could not parse %q as a valid MAC address: %v", c.MAC, err)
+	}
+	pass, err := c.wakePassword()
+	if err != nil {
+		return err
+	}
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	err = sendEtherFrame(iface, broadcast, etherTypeWOL, magicPacket(hwaddr, pass))
+	if err != nil {
+		return fmt.Errorf("error attempting to wake %s: %v", hwaddr, err)
+	}
+	return nil
+}
+
+// magicPacket returns a Wake-On-LAN magic packet for the hardware address
+// mac, followed by the SecureON password pass if it is not empty.
+func magicPacket(mac net.HardwareAddr, pass []byte) []byte {
+	b := bytes.Repeat([]byte{0xff}, 6)
+	for i := 0; i < 16; i++ {
+		b = append(b, mac...)
+	}
+	return append(b, pass...)
+}
+
+// wakePassword returns the configured SecureON password,
+// or nil if no password is configured.
+func (c *config) wakePassword() ([]byte, error) {
+	if c.WakePassword == "" {
+		return nil, nil
+	}
+	pass, err := net.ParseMAC(c.WakePassword)
+	if err != nil || len(pass) != 6 {
+		return nil, fmt.Errorf("invalid wake-password: must be six bytes in the form 01:02:03:04:05:06")
+	}
+	return pass, nil
+}
+
+// wakeNetwork returns the UDP network corresponding to the configured
+// wake-family.
+func wakeNetwork(c *config) (string, error) {
+	switch c.WakeFamily {
+	case "", "ip4":
+		return "udp4", nil
+	case "ip6":
+		return "udp6", nil
+	default:
+		return "", fmt.Errorf("invalid wake-family %q: must be ip4 or ip6", c.WakeFamily)
+	}
+}
+
+// checkFamily returns an error if addr is a literal IP address that is not
+// in the address family of network.
+func checkFamily(network, name, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	switch is4 := ip.To4() != nil; {
+	case is4 && network == "udp6":
+		return fmt.Errorf("%s address %s is an IPv4 address but wake-family is ip6", name, addr)
+	case !is4 && network == "udp4":
+		return fmt.Errorf("%s address %s is an IPv6 address but wake-family is ip4", name, addr)
+	}
+	return nil
+}
+
+// sameFamily returns an error if the local and remote wake addresses are
+// not in the same address family. An unspecified local IP address matches
+// either family.
+func sameFamily(local, remote *net.UDPAddr) error {
+	if len(local.IP) == 0 || local.IP.IsUnspecified() {
+		return nil
+	}
+	local4 := local.IP.To4() != nil
+	if local4 == (remote.IP.To4() != nil) {
+		return nil
+	}
+	family := func(is4 bool) string {
+		if is4 {
+			return "IPv4"
+		}
+		return "IPv6"
+	}
+	return fmt.Errorf("wake-local address %s is %s but wake-remote address %s is %s",
+		local.IP, family(local4), remote.IP, family(!local4))
+}
+
+// interfaceIP returns the first IPv4 or IPv6 address of the named interface.
+func interfaceIP(name string, ip4 bool) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && (n.IP.To4() != nil) == ip4 {
+			return n.IP, nil
+		}
+	}
+	if ip4 {
+		return nil, fmt.Errorf("interface %s has no IPv4 address", name)
+	}
+	return nil, fmt.Errorf("interface %s has no IPv6 address", name)
+}
+
+// isWireGuard returns whether the named interface is a WireGuard device.
+func isWireGuard(name string) bool {
+	uevent, err := ioutil.ReadFile(filepath.Join("/sys/class/net", name, "uevent"))
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(uevent, []byte("DEVTYPE=wireguard"))
+}
@@ -0,0 +1,62 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// wakeWebhook wakes the server by making the configured HTTP request to
+// webhook-url, for example to trigger a Home Assistant automation or a
+// router's wake endpoint. The request succeeds if the response has a 2xx
+// status.
+func wakeWebhook(c *config) error {
+	if c.WebhookURL == "" {
+		return errors.New("http wake-mode requires webhook-url")
+	}
+	method := http.MethodPost
+	if c.WebhookMethod != "" {
+		method = strings.ToUpper(c.WebhookMethod)
+	}
+	var body io.Reader
+	if c.WebhookBody != "" {
+		body = strings.NewReader(c.WebhookBody)
+	}
+	req, err := http.NewRequest(method, c.WebhookURL, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.WebhookHeaders {
+		req.Header.Set(k, v)
+	}
+	if len(c.WebhookTokenCommand) != 0 {
+		token, err := secret("", c.WebhookTokenCommand)
+		if err != nil {
+			return fmt.Errorf("could not get webhook token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client, err := tlsClient(c, "webhook", c.WebhookFingerprint, false)
+	if err != nil {
+		return err
+	}
+	defer sending()()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook %s %s: %s: %s", method, c.WebhookURL, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
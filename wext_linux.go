@@ -0,0 +1,129 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Wireless extensions constants from linux/wireless.h.
+const (
+	siocgiwap       = 0x8b15
+	siocgiwessid    = 0x8b1b
+	iwESSIDMaxSize  = 32
+	iwreqUnionBytes = 16
+)
+
+// iwPoint is the struct iw_point member of union iwreq_data.
+type iwPoint struct {
+	pointer uintptr
+	length  uint16
+	flags   uint16
+}
+
+// iwreqPoint is a struct iwreq holding an iw_point.
+type iwreqPoint struct {
+	name  [syscall.IFNAMSIZ]byte
+	point iwPoint
+	_     [iwreqUnionBytes - unsafe.Sizeof(iwPoint{})]byte
+}
+
+// iwreqAddr is a struct iwreq holding a struct sockaddr.
+type iwreqAddr struct {
+	name   [syscall.IFNAMSIZ]byte
+	family uint16
+	data   [14]byte
+}
+
+// wextInterfaces returns the wireless interfaces that the host is connected
+// to and their ESSIDs and access points using the wireless extensions ioctls
+// on each interface with a wireless directory in sysfs. Signal levels are
+// read from /proc/net/wireless.
+func wextInterfaces() ([]connection, error) {
+	ifaces, err := filepath.Glob("/sys/class/net/*/wireless")
+	if err != nil {
+		return nil, err
+	}
+	if len(ifaces) == 0 {
+		return nil, nil
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(fd)
+
+	levels := wirelessLevels()
+	var conns []connection
+	for _, path := range ifaces {
+		name := filepath.Base(filepath.Dir(path))
+		if len(name) >= syscall.IFNAMSIZ {
+			continue
+		}
+
+		var essid [iwESSIDMaxSize + 1]byte
+		var req iwreqPoint
+		copy(req.name[:], name)
+		req.point.pointer = uintptr(unsafe.Pointer(&essid[0]))
+		req.point.length = uint16(len(essid))
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocgiwessid, uintptr(unsafe.Pointer(&req)))
+		runtime.KeepAlive(&essid)
+		if errno != 0 {
+			// Interfaces without wireless extensions
+			// support cannot report an ESSID.
+			continue
+		}
+		n := int(req.point.length)
+		if n == 0 || n > iwESSIDMaxSize {
+			continue
+		}
+		conn := connection{essid: string(essid[:n]), iface: name, signal: levels[name]}
+
+		var ap iwreqAddr
+		copy(ap.name[:], name)
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocgiwap, uintptr(unsafe.Pointer(&ap)))
+		if errno == 0 {
+			conn.bssid = net.HardwareAddr(ap.data[:6]).String()
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// wirelessLevels returns the signal levels in dBm reported in
+// /proc/net/wireless, keyed by interface name.
+func wirelessLevels() map[string]int {
+	b, err := ioutil.ReadFile("/proc/net/wireless")
+	if err != nil {
+		return nil
+	}
+	levels := make(map[string]int)
+	for _, l := range strings.Split(string(b), "\n") {
+		// Interface lines are of the form
+		//  wlan0: 0000   70.  -40.  -256        0 ...
+		i := strings.Index(l, ":")
+		if i < 0 {
+			continue
+		}
+		f := strings.Fields(l[i+1:])
+		if len(f) < 3 {
+			continue
+		}
+		level, err := strconv.ParseFloat(strings.TrimSuffix(f[2], "."), 64)
+		if err != nil || level >= 0 {
+			continue
+		}
+		levels[strings.TrimSpace(l[:i])] = int(level)
+	}
+	return levels
+}
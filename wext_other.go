@@ -0,0 +1,14 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func wextInterfaces() ([]connection, error) {
+	return nil, errors.New("wireless extensions not supported on this platform")
+}
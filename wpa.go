@@ -0,0 +1,129 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// wpaCtrlDir is the default wpa_supplicant
+	// control interface directory.
+	wpaCtrlDir = "/run/wpa_supplicant"
+
+	// wpaTimeout is the time allowed for wpa_supplicant
+	// to reply to a control interface request.
+	wpaTimeout = 5 * time.Second
+)
+
+// wpaESSIDs returns the ESSIDs of wireless networks that the host is
+// connected to by querying the wpa_supplicant control interface.
+func wpaESSIDs(c *config) ([]string, error) {
+	conns, err := wpaConnections(c)
+	return connectionESSIDs(conns), err
+}
+
+// wpaConnections returns the wireless interfaces that the host is connected
+// to and their ESSIDs, access points and signal levels by querying the
+// wpa_supplicant control interface socket of each interface in the
+// configured control interface directory.
+func wpaConnections(c *config) ([]connection, error) {
+	dir := c.WPACtrlDir
+	if dir == "" {
+		dir = wpaCtrlDir
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var conns []connection
+	for _, e := range entries {
+		if e.Mode()&os.ModeSocket == 0 || strings.HasPrefix(e.Name(), "p2p-dev-") {
+			continue
+		}
+		status, err := wpaRequest(filepath.Join(dir, e.Name()), "STATUS")
+		if err != nil {
+			return nil, fmt.Errorf("wpa_supplicant %s: %v", e.Name(), err)
+		}
+		if status["wpa_state"] != "COMPLETED" {
+			continue
+		}
+		ssid, ok := status["ssid"]
+		if !ok {
+			continue
+		}
+		conn := connection{essid: wpaUnescape(ssid), iface: e.Name(), bssid: status["bssid"]}
+		poll, err := wpaRequest(filepath.Join(dir, e.Name()), "SIGNAL_POLL")
+		if err == nil {
+			conn.signal, _ = strconv.Atoi(poll["RSSI"])
+		}
+		conns = append(conns, conn)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].iface < conns[j].iface })
+	return conns, nil
+}
+
+// wpaRequest sends the command cmd to the wpa_supplicant control interface
+// socket at path and returns the key=value pairs of the reply.
+func wpaRequest(path, cmd string) (map[string]string, error) {
+	local := filepath.Join(os.TempDir(), fmt.Sprintf("bit-user-callback-%d-%s", os.Getpid(), filepath.Base(path)))
+	os.Remove(local)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: local, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(local)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(wpaTimeout))
+	_, err = conn.WriteToUnix([]byte(cmd), &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		reply := string(buf[:n])
+		if strings.HasPrefix(reply, "<") {
+			// Ignore unsolicited event messages.
+			continue
+		}
+		if strings.HasPrefix(reply, "FAIL") {
+			return nil, fmt.Errorf("%s failed", cmd)
+		}
+		pairs := make(map[string]string)
+		for _, l := range strings.Split(reply, "\n") {
+			i := strings.Index(l, "=")
+			if i < 0 {
+				continue
+			}
+			pairs[l[:i]] = l[i+1:]
+		}
+		return pairs, nil
+	}
+}
+
+// wpaUnescape returns the SSID s reported by wpa_supplicant with its
+// escaping of non-printable characters removed.
+func wpaUnescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	u, err := strconv.Unquote(`"` + strings.ReplaceAll(s, `\e`, `\x1b`) + `"`)
+	if err != nil {
+		return s
+	}
+	return u
+}